@@ -0,0 +1,248 @@
+// Command benchmark encodes a sample video with each encoder preset, the way
+// normalize does, and reports how long each took, how big the result is and how
+// close it is to the source, so presets can be picked from data.
+//
+// Usage:
+//
+//	benchmark [-presets fast,balanced] [-profile landscape] [-fill pad] [-json] sample.mp4
+//
+// SSIM is always measured. VMAF needs an ffmpeg built with libvmaf and is left out
+// when the filter isn't available. Both compare the encode with the source fitted
+// to the same profile, so they measure what the encoder lost and not the resize.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/ffmpeg"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/probe"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+// Result is one preset's encode of the sample.
+type Result struct {
+	Preset     string `json:"preset"`
+	X264Preset string `json:"x264Preset"`
+	CRF        int    `json:"crf"`
+	// Seconds is how long the encode took.
+	Seconds float64 `json:"seconds"`
+	// Speed is the sample's duration over the encode time. Above 1 is faster than real time.
+	Speed float64 `json:"speed"`
+	// Size is the encode's size in bytes, and Bitrate its average in kbit/s.
+	Size    int64   `json:"size"`
+	Bitrate float64 `json:"bitrate"`
+	SSIM    float64 `json:"ssim"`
+	// VMAF is nil when ffmpeg has no libvmaf.
+	VMAF  *float64 `json:"vmaf,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func main() {
+	presetNames := flag.String("presets", strings.Join(encoder.Names(), ","), "comma separated presets to encode with")
+	profileName := flag.String("profile", profile.Default, "profile to encode to: "+strings.Join(profile.Names(), ", "))
+	fill := flag.String("fill", profile.FillPad, "how to fill the rest of the frame: pad or blur")
+	dir := flag.String("dir", "", "keep the encodes in this directory instead of deleting them")
+	timeout := flag.Duration("timeout", 0, "give up on an encode after this long, 0 waits for it")
+	asJSON := flag.Bool("json", false, "print the results as JSON instead of a table")
+	flag.Parse()
+	logging.Setup("benchmark")
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: benchmark [flags] sample.mp4")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	sample := flag.Arg(0)
+
+	p, err := profile.Lookup(*profileName)
+	if err != nil {
+		logging.Fatal("Invalid profile", "error", err)
+	}
+	if err := profile.ValidateFill(*fill); err != nil {
+		logging.Fatal("Invalid fill", "error", err)
+	}
+	var presets []encoder.Preset
+	for _, name := range strings.Split(*presetNames, ",") {
+		preset, err := encoder.Lookup(strings.TrimSpace(name))
+		if err != nil {
+			logging.Fatal("Invalid preset", "error", err)
+		}
+		presets = append(presets, preset)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source, err := probe.Run(ctx, sample)
+	if err == nil {
+		err = probe.Inspect(source)
+	}
+	if err != nil {
+		logging.Fatal("Can't use the sample", "sample", sample, "error", err)
+	}
+	slog.Info("Probed sample", "sample", sample, "probe", source.Summary())
+
+	outDir := *dir
+	if outDir == "" {
+		outDir, err = os.MkdirTemp("", "benchmark-")
+		if err != nil {
+			logging.Fatal("Error creating workspace", "error", err)
+		}
+		defer os.RemoveAll(outDir)
+	} else if err := os.MkdirAll(outDir, 0o755); err != nil {
+		logging.Fatal("Error creating output directory", "error", err)
+	}
+
+	b := &benchmark{sample: sample, duration: source.Duration(), profile: p, fill: *fill, timeout: *timeout, vmaf: true}
+	var results []*Result
+	failed := false
+	for _, preset := range presets {
+		result := b.run(ctx, preset, filepath.Join(outDir, preset.Name+".mp4"))
+		if result.Error != "" {
+			failed = true
+		}
+		results = append(results, result)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		printTable(results)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// benchmark encodes the sample and measures the encodes.
+type benchmark struct {
+	sample   string
+	duration float64
+	profile  profile.Profile
+	fill     string
+	timeout  time.Duration
+	// vmaf is cleared once ffmpeg turns out not to have libvmaf.
+	vmaf bool
+}
+
+// run encodes the sample with preset to output and measures the result.
+func (b *benchmark) run(ctx context.Context, preset encoder.Preset, output string) *Result {
+	result := &Result{Preset: preset.Name, X264Preset: preset.X264Preset, CRF: preset.CRF}
+	logger := slog.With("preset", preset.Name)
+
+	// The same encode normalize runs, without the loudness analysis
+	args := []string{"-i", b.sample, "-map", "0:v:0", "-map", "0:a:0?", "-vf", b.profile.Filter(b.fill)}
+	args = append(args, preset.VideoArgs()...)
+	args = append(args, encoder.AudioArgs()...)
+	args = append(args, output)
+
+	logger.Info("Encoding")
+	start := time.Now()
+	_, err := ffmpeg.Run(ctx, args, &ffmpeg.Options{
+		Timeout:          b.timeout,
+		ProgressInterval: 10 * time.Second,
+		OnProgress: func(p ffmpeg.Progress) {
+			logger.Info("Encode progress", "progress", p)
+		},
+	})
+	if err != nil {
+		logger.Error("Encode failed", "error", err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Seconds = time.Since(start).Seconds()
+	if result.Seconds > 0 {
+		result.Speed = b.duration / result.Seconds
+	}
+	if stat, err := os.Stat(output); err == nil {
+		result.Size = stat.Size()
+		if b.duration > 0 {
+			result.Bitrate = float64(result.Size) * 8 / 1000 / b.duration
+		}
+	}
+
+	result.SSIM, err = b.compare(ctx, output, "ssim", ssimPattern)
+	if err != nil {
+		logger.Error("SSIM failed", "error", err)
+		result.Error = err.Error()
+		return result
+	}
+	if b.vmaf {
+		vmaf, err := b.compare(ctx, output, "libvmaf", vmafPattern)
+		var ffErr *ffmpeg.Error
+		switch {
+		case errors.As(err, &ffErr) && strings.Contains(ffErr.Stderr, "No such filter: 'libvmaf'"):
+			logger.Warn("ffmpeg was built without libvmaf, leaving out VMAF")
+			b.vmaf = false
+		case err != nil:
+			logger.Error("VMAF failed", "error", err)
+			result.Error = err.Error()
+		default:
+			result.VMAF = &vmaf
+		}
+	}
+	logger.Info("Encoded", "seconds", result.Seconds, "size", result.Size, "ssim", result.SSIM)
+	return result
+}
+
+var (
+	ssimPattern = regexp.MustCompile(`SSIM .*All:([0-9.]+)`)
+	vmafPattern = regexp.MustCompile(`VMAF score: ([0-9.]+)`)
+)
+
+// compare runs a full reference metric filter with the encode as the distorted input
+// and the source, fitted to the profile, as the reference, and returns the score
+// pattern finds in ffmpeg's output.
+func (b *benchmark) compare(ctx context.Context, encoded, filter string, pattern *regexp.Regexp) (float64, error) {
+	// Both inputs need the same frame rate, timestamps and pixel format to line up frame for frame
+	const prepare = "settb=AVTB,setpts=PTS-STARTPTS,format=yuv420p"
+	graph := fmt.Sprintf("[1:v]%s,%s[ref];[0:v]%s[dist];[dist][ref]%s", b.profile.Filter(b.fill), prepare, prepare, filter)
+	result, err := ffmpeg.Run(ctx, []string{"-i", encoded, "-i", b.sample, "-lavfi", graph, "-f", "null", "-"}, &ffmpeg.Options{Timeout: b.timeout})
+	if err != nil {
+		return 0, err
+	}
+	match := pattern.FindStringSubmatch(result.Stderr)
+	if match == nil {
+		return 0, fmt.Errorf("no %s score in ffmpeg output", filter)
+	}
+	return strconv.ParseFloat(match[1], 64)
+}
+
+func printTable(results []*Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "preset\tx264\tcrf\ttime (s)\tspeed\tsize (MiB)\tkbit/s\tssim\tvmaf\t")
+	for _, r := range results {
+		if r.Error != "" && r.Seconds == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%d\tfailed\t\t\t\t\t\t\n", r.Preset, r.X264Preset, r.CRF)
+			continue
+		}
+		vmaf := "-"
+		if r.VMAF != nil {
+			vmaf = fmt.Sprintf("%.2f", *r.VMAF)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1f\t%.2fx\t%.2f\t%.0f\t%.4f\t%s\t\n",
+			r.Preset, r.X264Preset, r.CRF, r.Seconds, r.Speed, float64(r.Size)/(1<<20), r.Bitrate, r.SSIM, vmaf)
+	}
+	w.Flush()
+}
@@ -0,0 +1,147 @@
+// Command pipeline runs download, normalize and concatenate in one process
+// against a local directory instead of Cloud Storage.
+//
+// Usage:
+//
+//	pipeline [-dir ./pipeline-data] [-addr :8080] [-compile] [url ...]
+//
+// Each URL is downloaded into the quarantine directory. Any file that lands in
+// the quarantine directory, including ones copied there by hand, is normalized
+// through the same CloudEvent handler Cloud Functions calls. With -compile the
+// normalized videos are concatenated once the quarantine directory is drained.
+// With -addr the stages are also served over HTTP until interrupted.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"github.com/DC00/meme-compiler-cloud-functions/video/concatenate/concatenator"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/downloader"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/jobs"
+	"github.com/DC00/meme-compiler-cloud-functions/video/normalize/normalizer"
+)
+
+func main() {
+	dir := flag.String("dir", "pipeline-data", "directory that stands in for Cloud Storage; each bucket is a subdirectory")
+	addr := flag.String("addr", "", "serve /download and /concatenate on this address until interrupted")
+	compile := flag.Bool("compile", false, "concatenate the normalized videos once the quarantine directory is drained")
+	pollInterval := flag.Duration("poll", time.Second, "how often to check the quarantine directory for new files")
+	start := flag.String("start", "", "keep each URL's video from this timestamp, e.g. 1:35")
+	end := flag.String("end", "", "keep each URL's video up to this timestamp")
+	flag.Parse()
+	logging.Setup("pipeline")
+
+	cfg, err := config.Load(config.ServicePipeline)
+	if err != nil {
+		logging.Fatal("Invalid configuration", "error", err)
+	}
+	slog.Info("Effective configuration", "config", cfg)
+
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), "pipeline")
+	if err != nil {
+		logging.Fatal("Error setting up telemetry", "error", err)
+	}
+	defer func() {
+		if err := shutdownTelemetry(context.Background()); err != nil {
+			slog.Error("Error flushing telemetry", "error", err)
+		}
+	}()
+
+	local, err := blobstore.NewLocal(*dir)
+	if err != nil {
+		logging.Fatal("Error creating local store", "error", err)
+	}
+	slog.Info("Using local store", "dir", *dir)
+	store := blobstore.Instrument(local)
+
+	// Job state sits next to the buckets so it can be inspected after the run
+	jobStore, err := jobs.NewFile(filepath.Join(*dir, ".jobs"))
+	if err != nil {
+		logging.Fatal("Error creating job store", "error", err)
+	}
+
+	download, err := downloader.New(store, jobStore, cfg)
+	if err != nil {
+		logging.Fatal("Error creating download service", "error", err)
+	}
+	normalize := normalizer.New(store, cfg)
+	concatenate := concatenator.New(store, cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := newWatcher(store, cfg.Buckets.Quarantine, normalize.NormalizeVideo, *pollInterval, cfg.Normalize.MaxAttempts)
+
+	for _, url := range flag.Args() {
+		submit(download.Handler, downloader.Submission{URL: url, Start: *start, End: *end})
+	}
+
+	if *addr != "" {
+		go w.run(ctx)
+		serve(ctx, *addr, download, normalize, concatenate.ConcatenateVideos)
+		download.Wait()
+		return
+	}
+
+	// Downloads run in the background, so let them land in quarantine before draining it
+	download.Wait()
+
+	// One-shot mode: normalize everything in quarantine, then optionally compile
+	if err := w.drain(ctx); err != nil {
+		logging.Fatal("Error normalizing videos", "error", err)
+	}
+	if *compile {
+		rec := httptest.NewRecorder()
+		concatenate.ConcatenateVideos(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+		slog.Info("Concatenate responded", "status", rec.Code, "body", rec.Body.String())
+	}
+}
+
+// submit runs a submission through the download handler exactly as the Cloud Run service receives it.
+func submit(handler http.HandlerFunc, submission downloader.Submission) {
+	body, err := json.Marshal(submission)
+	if err != nil {
+		slog.Error("Failed to encode submission", "error", err)
+		return
+	}
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	slog.Info("Download responded", "url", submission.URL, "status", rec.Code, "body", rec.Body.String())
+}
+
+func serve(ctx context.Context, addr string, download *downloader.Service, normalize *normalizer.Service, concatenate http.HandlerFunc) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/download", download.Handler)
+	mux.HandleFunc("GET /jobs/{id}", download.JobHandler)
+	mux.Handle("/normalize/", http.StripPrefix("/normalize", normalize.AdminHandler()))
+	mux.HandleFunc("/concatenate", concatenate)
+	server := &http.Server{Addr: addr, Handler: logging.Middleware(mux)}
+
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+
+	slog.Info("Listening", "addr", addr)
+	fmt.Fprintf(os.Stderr, "  curl -X POST %s/download -d '{\"url\": \"...\"}'\n  curl %s/jobs/<id>\n  curl %s/normalize/failed\n  curl -X POST %s/concatenate\n", addr, addr, addr, addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logging.Fatal("Server failed", "error", err)
+	}
+}
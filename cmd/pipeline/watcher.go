@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/video/normalize/normalizer"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// finalizedEventType is the Eventarc event the normalize function is triggered by.
+const finalizedEventType = "google.cloud.storage.object.v1.finalized"
+
+// watcher polls the quarantine bucket and hands each new object to the normalize
+// handler as a synthesized Cloud Storage finalize event.
+type watcher struct {
+	store    blobstore.Store
+	bucket   string
+	handle   func(context.Context, event.Event) error
+	interval time.Duration
+
+	// attempted records the generation of every object the handler finished with,
+	// so it is only handed each one once.
+	attempted map[string]int64
+	// failures counts the failed deliveries of each object. Like Eventarc, the watcher
+	// retries them on the next poll, normally until normalize gives up on the object
+	// itself, and stops after maxAttempts in case it never does.
+	failures    map[delivery]int
+	maxAttempts int
+}
+
+// delivery identifies one generation of an object.
+type delivery struct {
+	name       string
+	generation int64
+}
+
+func newWatcher(store blobstore.Store, bucket string, handle func(context.Context, event.Event) error, interval time.Duration, maxAttempts int) *watcher {
+	return &watcher{
+		store:       store,
+		bucket:      bucket,
+		handle:      handle,
+		interval:    interval,
+		attempted:   make(map[string]int64),
+		failures:    make(map[delivery]int),
+		maxAttempts: maxAttempts,
+	}
+}
+
+// run polls until the context is cancelled.
+func (w *watcher) run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.poll(ctx); err != nil {
+			slog.ErrorContext(ctx, "Error polling", "bucket", w.bucket, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain polls until a pass finds nothing new to normalize.
+func (w *watcher) drain(ctx context.Context) error {
+	for {
+		n, err := w.poll(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// poll normalizes every object not handled yet and returns how many it handed over.
+func (w *watcher) poll(ctx context.Context) (int, error) {
+	objects, err := blobstore.ListAll(ctx, w.store, w.bucket, "")
+	if err != nil {
+		return 0, err
+	}
+
+	handled := 0
+	for _, object := range objects {
+		if ctx.Err() != nil {
+			return handled, ctx.Err()
+		}
+		if w.attempted[object.Name] == object.Generation {
+			continue
+		}
+		d := delivery{name: object.Name, generation: object.Generation}
+		if w.failures[d] >= w.maxAttempts {
+			continue
+		}
+		handled++
+
+		e, err := finalizedEvent(object)
+		if err != nil {
+			return handled, err
+		}
+		if err := w.handle(ctx, e); err != nil {
+			w.failures[d]++
+			slog.ErrorContext(ctx, "Normalize failed", "object", object.Name, "failures", w.failures[d], "error", err)
+			continue
+		}
+		w.attempted[object.Name] = object.Generation
+		delete(w.failures, d)
+	}
+	return handled, nil
+}
+
+// finalizedEvent builds the CloudEvent Eventarc delivers when an object is written.
+func finalizedEvent(object *blobstore.Attrs) (event.Event, error) {
+	e := event.New()
+	e.SetID(fmt.Sprintf("%s-%d", object.Name, object.Generation))
+	e.SetSource(fmt.Sprintf("//storage.googleapis.com/projects/_/buckets/%s", object.Bucket))
+	e.SetType(finalizedEventType)
+	e.SetSubject("objects/" + object.Name)
+	e.SetTime(object.Updated)
+
+	data := normalizer.StorageObjectData{
+		Bucket:     object.Bucket,
+		Name:       object.Name,
+		Generation: strconv.FormatInt(object.Generation, 10),
+	}
+	if err := e.SetData(event.ApplicationJSON, data); err != nil {
+		return e, fmt.Errorf("event.SetData: %v", err)
+	}
+	e.SetExtension("generation", strconv.FormatInt(object.Generation, 10))
+	return e, nil
+}
@@ -0,0 +1,54 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/DC00/meme-compiler/client"
+)
+
+// addVideoRequest is client.AddVideoRequest with the part of the video to keep and
+// who submitted it, which the pinned client has no fields for. The API passes them on
+// to the download service's submission.
+type addVideoRequest struct {
+	client.AddVideoRequest
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Submitter is the Discord username credited in the compilation.
+	Submitter string `json:"submitter,omitempty"`
+}
+
+// addVideo submits a video to the API the way client.VideoService.Add does. Add only
+// takes a client.AddVideoRequest and the client's request helper is unexported, so
+// the request is sent here until the client has the fields.
+func addVideo(ctx context.Context, req *addVideoRequest) (*client.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return &client.Response{Message: "Encoding request body failed"}, fmt.Errorf("json.Marshal: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.Discord.APIURL+"/api/videos/v1/add", bytes.NewReader(body))
+	if err != nil {
+		return &client.Response{Message: "Creating request failed"}, fmt.Errorf("http.NewRequest: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+cfg.Discord.IdentityToken.Value())
+
+	resp, err := apiClient.Do(httpReq)
+	if err != nil {
+		return &client.Response{Message: "Making request failed"}, fmt.Errorf("apiClient.Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response client.Response
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return &client.Response{Message: "Decoding response failed"}, fmt.Errorf("json.Decode: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return &response, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return &response, nil
+}
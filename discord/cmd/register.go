@@ -50,6 +50,16 @@ func main() {
 				Type:        discordgo.ApplicationCommandOptionString,
 				Required:    true,
 			},
+			{
+				Name:        "start",
+				Description: "Where the meme starts in the video, like 1:35",
+				Type:        discordgo.ApplicationCommandOptionString,
+			},
+			{
+				Name:        "end",
+				Description: "Where the meme ends in the video, like 1:50",
+				Type:        discordgo.ApplicationCommandOptionString,
+			},
 		},
 	}
 
@@ -3,6 +3,7 @@ module github.com/DC00/meme-compiler-cloud-functions/discord
 go 1.22.3
 
 require (
+	github.com/DC00/meme-compiler-cloud-functions/shared v0.0.0
 	github.com/DC00/meme-compiler/client v0.0.0-20240705174520-661df6a2393c
 	github.com/GoogleCloudPlatform/functions-framework-go v1.8.1
 	github.com/bwmarrin/discordgo v0.28.1
@@ -19,5 +20,7 @@ require (
 	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/crypto v0.25.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/DC00/meme-compiler-cloud-functions/shared => ../shared
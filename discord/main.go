@@ -8,57 +8,77 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"time"
 
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/trim"
 	"github.com/DC00/meme-compiler/client"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/bwmarrin/discordgo"
 )
 
-var (
-	identityToken = os.Getenv("IDENTITY_TOKEN")
-	discordPubKey = os.Getenv("DISCORD_PUBLIC_KEY")
-)
+var cfg *config.Config
+
+// apiClient passes the interaction's correlation ID on to the Meme Compiler API.
+var apiClient = &http.Client{Timeout: 10 * time.Second, Transport: &logging.Transport{}}
 
 func init() {
-	functions.HTTP("HandleRequest", handleRequest)
+	logging.Setup("discord")
+
+	// Fail the deploy if IDENTITY_TOKEN or DISCORD_PUBLIC_KEY are missing or malformed
+	var err error
+	cfg, err = config.Load(config.ServiceDiscord)
+	if err != nil {
+		logging.Fatal("Invalid configuration", "error", err)
+	}
+	slog.Info("Effective configuration", "config", cfg)
+
+	// The function receives every path, so route the debug endpoint ourselves
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleRequest)
+	if cfg.DebugEndpoints {
+		mux.HandleFunc("/debug/config", config.Handler(cfg))
+	}
+	functions.HTTP("HandleRequest", mux.ServeHTTP)
 }
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
 	if !verifyRequest(r) {
-		log.Println("Invalid request signature")
+		slog.Warn("Invalid request signature")
 		http.Error(w, "Invalid request signature", http.StatusUnauthorized)
 		return
 	}
 
 	var interaction discordgo.Interaction
 	if err := json.NewDecoder(r.Body).Decode(&interaction); err != nil {
-		log.Printf("Failed to decode request body: %v", err)
+		slog.Warn("Failed to decode request body", "error", err)
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Interaction type: %v", interaction.Type)
-
 	// Handle PING requests
 	if interaction.Type == discordgo.InteractionPing {
-		log.Println("Handling PING request")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"type": 1,
 		})
-		log.Println("Returned PING response with type 1")
+		slog.Debug("Returned PING response with type 1")
 		return
 	}
 
-	response := handleInteraction(interaction)
+	// Every meme starts here, so this is where its correlation ID is made. It follows
+	// the video through download, normalize and concatenate.
+	ctx := logging.WithID(r.Context(), logging.NewID())
+	slog.InfoContext(ctx, "Handling interaction", "interaction", interaction.ID, "type", interaction.Type.String())
+
+	response := handleInteraction(ctx, interaction)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
-	log.Println("Returned response for interaction")
 }
 
 func verifyRequest(r *http.Request) bool {
@@ -66,35 +86,35 @@ func verifyRequest(r *http.Request) bool {
 	signature := r.Header.Get("X-Signature-Ed25519")
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
+		slog.Warn("Failed to read request body", "error", err)
 		return false
 	}
 	r.Body = io.NopCloser(bytes.NewBuffer(body)) // Reset the body reader
 
-	decodedPubKey, err := hex.DecodeString(discordPubKey)
+	decodedPubKey, err := hex.DecodeString(cfg.Discord.PublicKey)
 	if err != nil {
-		log.Printf("Failed to decode public key: %v", err)
+		slog.Error("Failed to decode public key", "error", err)
 		return false
 	}
 
 	message := append([]byte(timestamp), body...)
 	decodedSignature, err := hex.DecodeString(signature)
 	if err != nil {
-		log.Printf("Failed to decode signature: %v", err)
+		slog.Warn("Failed to decode signature", "error", err)
 		return false
 	}
 
 	valid := ed25519.Verify(decodedPubKey, message, decodedSignature)
 	if !valid {
-		log.Println("Signature verification failed")
+		slog.Warn("Signature verification failed")
 	}
 	return valid
 }
 
-func handleInteraction(interaction discordgo.Interaction) *discordgo.InteractionResponse {
+func handleInteraction(ctx context.Context, interaction discordgo.Interaction) *discordgo.InteractionResponse {
 	if interaction.Type == discordgo.InteractionApplicationCommand {
 		data := interaction.ApplicationCommandData()
-		log.Printf("Handling command: %v", data.Name)
+		slog.InfoContext(ctx, "Handling command", "command", data.Name)
 		switch data.Name {
 		case "ping":
 			return &discordgo.InteractionResponse{
@@ -104,25 +124,41 @@ func handleInteraction(interaction discordgo.Interaction) *discordgo.Interaction
 				},
 			}
 		case "addvideo":
-			return handleAddVideo(data)
+			return handleAddVideo(ctx, data, submitter(interaction))
 		case "createcompilation":
-			return handleCreateCompilation()
+			return handleCreateCompilation(ctx)
 		}
 	}
 	return nil
 }
 
-func handleAddVideo(data discordgo.ApplicationCommandInteractionData) *discordgo.InteractionResponse {
-	var videoURL string
+// submitter returns the name of the user who ran the command. Member is set in a
+// server and User in a DM.
+func submitter(interaction discordgo.Interaction) string {
+	switch {
+	case interaction.Member != nil && interaction.Member.User != nil:
+		return interaction.Member.User.Username
+	case interaction.User != nil:
+		return interaction.User.Username
+	}
+	return ""
+}
+
+func handleAddVideo(ctx context.Context, data discordgo.ApplicationCommandInteractionData, submitter string) *discordgo.InteractionResponse {
+	var videoURL, start, end string
 	for _, option := range data.Options {
-		if option.Name == "url" {
+		switch option.Name {
+		case "url":
 			videoURL = option.StringValue()
-			break
+		case "start":
+			start = option.StringValue()
+		case "end":
+			end = option.StringValue()
 		}
 	}
 
 	if videoURL == "" {
-		log.Println("No video URL provided")
+		slog.InfoContext(ctx, "No video URL provided")
 		return &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
@@ -131,14 +167,26 @@ func handleAddVideo(data discordgo.ApplicationCommandInteractionData) *discordgo
 		}
 	}
 
-	c := client.NewClient(identityToken)
+	// Check the range here so a typo is answered straight away instead of failing the download
+	clipRange, err := trim.Parse(start, end)
+	if err != nil {
+		slog.InfoContext(ctx, "Invalid clip range", "start", start, "end", end, "error", err)
+		return &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: fmt.Sprintf("Invalid clip range: %v.", err),
+			},
+		}
+	}
 
-	ctx := context.Background()
-	addResp, err := c.Videos.Add(ctx, &client.AddVideoRequest{
-		URL: videoURL,
+	addResp, err := addVideo(ctx, &addVideoRequest{
+		AddVideoRequest: client.AddVideoRequest{URL: videoURL},
+		Start:           start,
+		End:             end,
+		Submitter:       submitter,
 	})
 	if err != nil {
-		log.Printf("Error adding video: %v", err)
+		slog.ErrorContext(ctx, "Error adding video", "url", videoURL, "error", err)
 		errorMessage := "Error adding video"
 		if addResp != nil {
 			errorMessage = addResp.Message
@@ -151,7 +199,7 @@ func handleAddVideo(data discordgo.ApplicationCommandInteractionData) *discordgo
 		}
 	}
 
-	log.Println("Successfully added video")
+	slog.InfoContext(ctx, "Successfully added video", "url", videoURL, "range", clipRange, "submitter", submitter)
 	return &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
@@ -160,13 +208,12 @@ func handleAddVideo(data discordgo.ApplicationCommandInteractionData) *discordgo
 	}
 }
 
-func handleCreateCompilation() *discordgo.InteractionResponse {
-	c := client.NewClient(identityToken)
+func handleCreateCompilation(ctx context.Context) *discordgo.InteractionResponse {
+	c := client.NewClient(cfg.Discord.IdentityToken.Value(), client.WithHTTPClient(apiClient), client.WithBaseURL(cfg.Discord.APIURL))
 
-	ctx := context.Background()
 	compResp, err := c.Compilations.Create(ctx, &client.CreateCompilationRequest{})
 	if err != nil {
-		log.Printf("Error creating compilation: %v", err)
+		slog.ErrorContext(ctx, "Error creating compilation", "error", err)
 		return &discordgo.InteractionResponse{
 			Type: discordgo.InteractionResponseChannelMessageWithSource,
 			Data: &discordgo.InteractionResponseData{
@@ -175,7 +222,7 @@ func handleCreateCompilation() *discordgo.InteractionResponse {
 		}
 	}
 
-	log.Println("Requested compilation creation")
+	slog.InfoContext(ctx, "Requested compilation creation")
 	return &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
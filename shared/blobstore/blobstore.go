@@ -0,0 +1,109 @@
+// Package blobstore is a small object store abstraction shared by the video services.
+// The GCS implementation is used in production, and the local filesystem implementation
+// lets the pipeline run on a laptop without GCP credentials.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotExist is returned when the requested object does not exist.
+	ErrNotExist = errors.New("blobstore: object does not exist")
+	// ErrPreconditionFailed is returned when a conditional write does not match the stored object.
+	ErrPreconditionFailed = errors.New("blobstore: precondition failed")
+	// ErrInvalidName is returned by the local store for a bucket or object name that
+	// would be stored outside the bucket's directory, such as one containing "..".
+	ErrInvalidName = errors.New("blobstore: invalid name")
+)
+
+// Attrs describes a stored object.
+type Attrs struct {
+	Bucket      string
+	Name        string
+	Size        int64
+	ContentType string
+	Created     time.Time
+	Updated     time.Time
+	// Generation changes every time the object is overwritten.
+	Generation int64
+	Metadata   map[string]string
+}
+
+// PutOptions control how an object is written. The zero value is an unconditional write.
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+	// IfNotExists fails the write with ErrPreconditionFailed if the object already exists.
+	IfNotExists bool
+	// IfGenerationMatch fails the write with ErrPreconditionFailed unless the stored
+	// object has this generation. Zero disables the check.
+	IfGenerationMatch int64
+	// ChunkSize is the resumable upload chunk size in bytes, which is also how much
+	// of the object the GCS client buffers in memory. Zero uses the store's default.
+	ChunkSize int
+}
+
+// ListOptions select a page of objects in a bucket.
+type ListOptions struct {
+	Prefix    string
+	PageToken string
+	// PageSize is the maximum number of objects returned. Zero uses the store's default.
+	PageSize int
+}
+
+// Page is a single page of listing results ordered by object name.
+type Page struct {
+	Objects []*Attrs
+	// NextPageToken is empty on the last page.
+	NextPageToken string
+}
+
+// Store is the set of object operations the video services need.
+type Store interface {
+	// Get opens the object for reading. The caller must close the reader.
+	Get(ctx context.Context, bucket, name string) (io.ReadCloser, error)
+	// Put writes the object from r, streaming it to the store.
+	Put(ctx context.Context, bucket, name string, r io.Reader, opts *PutOptions) (*Attrs, error)
+	// List returns a page of objects in the bucket.
+	List(ctx context.Context, bucket string, opts *ListOptions) (*Page, error)
+	// Delete removes the object.
+	Delete(ctx context.Context, bucket, name string) error
+	// Stat returns the object's attributes.
+	Stat(ctx context.Context, bucket, name string) (*Attrs, error)
+	// Update merges metadata into the object's metadata without rewriting it, so its
+	// generation stays the same. An empty value removes the key.
+	Update(ctx context.Context, bucket, name string, metadata map[string]string) (*Attrs, error)
+}
+
+// ListAll follows NextPageToken until every object matching the prefix has been read.
+func ListAll(ctx context.Context, store Store, bucket, prefix string) ([]*Attrs, error) {
+	var objects []*Attrs
+	opts := &ListOptions{Prefix: prefix}
+	for {
+		page, err := store.List(ctx, bucket, opts)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Objects...)
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		opts.PageToken = page.NextPageToken
+	}
+}
+
+// Exists reports whether the object exists.
+func Exists(ctx context.Context, store Store, bucket, name string) (bool, error) {
+	_, err := store.Stat(ctx, bucket, name)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,149 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+const gcsDefaultPageSize = 1000
+
+// GCS is a Store backed by Google Cloud Storage.
+type GCS struct {
+	client *storage.Client
+}
+
+// NewGCS creates a Cloud Storage client using Application Default Credentials.
+func NewGCS(ctx context.Context) (*GCS, error) {
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %v", err)
+	}
+	return &GCS{client: client}, nil
+}
+
+// Close closes the underlying Cloud Storage client.
+func (g *GCS) Close() error {
+	return g.client.Close()
+}
+
+func (g *GCS) Get(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	reader, err := g.client.Bucket(bucket).Object(name).NewReader(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return reader, nil
+}
+
+func (g *GCS) Put(ctx context.Context, bucket, name string, r io.Reader, opts *PutOptions) (*Attrs, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+
+	obj := g.client.Bucket(bucket).Object(name)
+	switch {
+	case opts.IfNotExists:
+		obj = obj.If(storage.Conditions{DoesNotExist: true})
+	case opts.IfGenerationMatch != 0:
+		obj = obj.If(storage.Conditions{GenerationMatch: opts.IfGenerationMatch})
+	}
+
+	// Cancelling the writer's context is how an upload is abandoned without
+	// creating the object
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	writer := obj.NewWriter(ctx)
+	writer.ContentType = opts.ContentType
+	writer.Metadata = opts.Metadata
+	if opts.ChunkSize > 0 {
+		writer.ChunkSize = opts.ChunkSize
+	}
+	if _, err := io.Copy(writer, r); err != nil {
+		cancel()
+		writer.Close()
+		return nil, gcsError(err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, gcsError(err)
+	}
+	return gcsAttrs(writer.Attrs()), nil
+}
+
+func (g *GCS) List(ctx context.Context, bucket string, opts *ListOptions) (*Page, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = gcsDefaultPageSize
+	}
+
+	it := g.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: opts.Prefix})
+	pager := iterator.NewPager(it, pageSize, opts.PageToken)
+
+	var objects []*storage.ObjectAttrs
+	nextPageToken, err := pager.NextPage(&objects)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+
+	page := &Page{NextPageToken: nextPageToken}
+	for _, attrs := range objects {
+		page.Objects = append(page.Objects, gcsAttrs(attrs))
+	}
+	return page, nil
+}
+
+func (g *GCS) Delete(ctx context.Context, bucket, name string) error {
+	return gcsError(g.client.Bucket(bucket).Object(name).Delete(ctx))
+}
+
+func (g *GCS) Stat(ctx context.Context, bucket, name string) (*Attrs, error) {
+	attrs, err := g.client.Bucket(bucket).Object(name).Attrs(ctx)
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return gcsAttrs(attrs), nil
+}
+
+func (g *GCS) Update(ctx context.Context, bucket, name string, metadata map[string]string) (*Attrs, error) {
+	attrs, err := g.client.Bucket(bucket).Object(name).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return nil, gcsError(err)
+	}
+	return gcsAttrs(attrs), nil
+}
+
+func gcsAttrs(attrs *storage.ObjectAttrs) *Attrs {
+	return &Attrs{
+		Bucket:      attrs.Bucket,
+		Name:        attrs.Name,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		Created:     attrs.Created,
+		Updated:     attrs.Updated,
+		Generation:  attrs.Generation,
+		Metadata:    attrs.Metadata,
+	}
+}
+
+// gcsError maps Cloud Storage errors onto the package's sentinel errors.
+func gcsError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("%w: %v", ErrNotExist, err)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+
+// instrumented wraps a Store with a span and metrics for every operation.
+type instrumented struct {
+	store    Store
+	tracer   trace.Tracer
+	duration metric.Float64Histogram
+	bytes    metric.Int64Counter
+}
+
+// Instrument returns a Store that records a span, a duration and the bytes moved
+// for every call to store, using the global OpenTelemetry providers.
+func Instrument(store Store) Store {
+	meter := otel.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram("blobstore.operation.duration",
+		metric.WithDescription("Time taken by object store operations, by operation and outcome."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...))
+	if err != nil {
+		otel.Handle(err)
+	}
+	bytes, err := meter.Int64Counter("blobstore.bytes",
+		metric.WithDescription("Bytes read from (in) and written to (out) the object store."),
+		metric.WithUnit("By"))
+	if err != nil {
+		otel.Handle(err)
+	}
+	return &instrumented{store: store, tracer: otel.Tracer(instrumentationName), duration: duration, bytes: bytes}
+}
+
+// start begins a span for the operation and returns a function that ends it and records the duration.
+func (s *instrumented) start(ctx context.Context, operation, bucket, name string) (context.Context, func(error)) {
+	attrs := []attribute.KeyValue{attribute.String("operation", operation), attribute.String("bucket", bucket)}
+	ctx, span := telemetry.Start(ctx, s.tracer, "blobstore."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attrs, attribute.String("object", name))...))
+	start := time.Now()
+	return ctx, func(err error) {
+		s.duration.Record(ctx, telemetry.Seconds(start), metric.WithAttributes(append(attrs, attribute.String("outcome", outcome(err)))...))
+		// A missing object is an answer, not a failure
+		if errors.Is(err, ErrNotExist) {
+			err = nil
+		}
+		telemetry.End(span, err)
+	}
+}
+
+func (s *instrumented) Get(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	ctx, end := s.start(ctx, "get", bucket, name)
+	r, err := s.store.Get(ctx, bucket, name)
+	end(err)
+	if err != nil {
+		return nil, err
+	}
+	// The bytes are only known once the caller has read them
+	return &countingReader{ReadCloser: r, done: func(n int64) {
+		s.bytes.Add(ctx, n, metric.WithAttributes(attribute.String("bucket", bucket), attribute.String("direction", "in")))
+	}}, nil
+}
+
+func (s *instrumented) Put(ctx context.Context, bucket, name string, r io.Reader, opts *PutOptions) (*Attrs, error) {
+	ctx, end := s.start(ctx, "put", bucket, name)
+	attrs, err := s.store.Put(ctx, bucket, name, r, opts)
+	end(err)
+	if err == nil {
+		s.bytes.Add(ctx, attrs.Size, metric.WithAttributes(attribute.String("bucket", bucket), attribute.String("direction", "out")))
+	}
+	return attrs, err
+}
+
+func (s *instrumented) List(ctx context.Context, bucket string, opts *ListOptions) (*Page, error) {
+	ctx, end := s.start(ctx, "list", bucket, "")
+	page, err := s.store.List(ctx, bucket, opts)
+	end(err)
+	return page, err
+}
+
+func (s *instrumented) Delete(ctx context.Context, bucket, name string) error {
+	ctx, end := s.start(ctx, "delete", bucket, name)
+	err := s.store.Delete(ctx, bucket, name)
+	end(err)
+	return err
+}
+
+func (s *instrumented) Stat(ctx context.Context, bucket, name string) (*Attrs, error) {
+	ctx, end := s.start(ctx, "stat", bucket, name)
+	attrs, err := s.store.Stat(ctx, bucket, name)
+	end(err)
+	return attrs, err
+}
+
+func (s *instrumented) Update(ctx context.Context, bucket, name string, metadata map[string]string) (*Attrs, error) {
+	ctx, end := s.start(ctx, "update", bucket, name)
+	attrs, err := s.store.Update(ctx, bucket, name, metadata)
+	end(err)
+	return attrs, err
+}
+
+// Close closes the wrapped store if it has a Close method, like the GCS store.
+func (s *instrumented) Close() error {
+	if c, ok := s.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// outcome is the metric attribute for an operation's result.
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotExist):
+		return "not_found"
+	case errors.Is(err, ErrPreconditionFailed):
+		return "precondition_failed"
+	default:
+		return "error"
+	}
+}
+
+// countingReader reports how many bytes were read when it is closed.
+type countingReader struct {
+	io.ReadCloser
+	n    int64
+	done func(int64)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	r.done(r.n)
+	return r.ReadCloser.Close()
+}
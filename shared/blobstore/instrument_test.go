@@ -0,0 +1,104 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	spans := tracetest.NewSpanRecorder()
+	meterProvider, tracerProvider := otel.GetMeterProvider(), otel.GetTracerProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() {
+		otel.SetMeterProvider(meterProvider)
+		otel.SetTracerProvider(tracerProvider)
+	})
+
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := Instrument(local)
+	if _, err := store.Put(ctx, "quarantine", "a.mp4", strings.NewReader("video"), &PutOptions{}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, err := store.Get(ctx, "quarantine", "a.mp4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	io.ReadAll(r)
+	r.Close()
+	if _, err := store.Stat(ctx, "quarantine", "missing.mp4"); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("Stat = %v, want ErrNotExist", err)
+	}
+
+	// One client span per call, and a missing object is not an error
+	ended := spans.Ended()
+	if len(ended) != 3 {
+		t.Fatalf("spans = %d, want 3", len(ended))
+	}
+	for i, want := range []struct{ name, object string }{
+		{"blobstore.put", "a.mp4"},
+		{"blobstore.get", "a.mp4"},
+		{"blobstore.stat", "missing.mp4"},
+	} {
+		span := ended[i]
+		if span.Name() != want.name || span.SpanKind() != trace.SpanKindClient || span.Status().Code == codes.Error {
+			t.Errorf("span %d = %s, %s, %v, want %s", i, span.Name(), span.SpanKind(), span.Status(), want.name)
+		}
+		attrs := attribute.NewSet(span.Attributes()...)
+		if object, _ := attrs.Value("object"); object.AsString() != want.object {
+			t.Errorf("span %s object = %q, want %q", span.Name(), object.AsString(), want.object)
+		}
+		if bucket, _ := attrs.Value("bucket"); bucket.AsString() != "quarantine" {
+			t.Errorf("span %s bucket = %q, want quarantine", span.Name(), bucket.AsString())
+		}
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	durations := map[string]uint64{}
+	bytes := map[string]int64{}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					operation, _ := dp.Attributes.Value("operation")
+					outcome, _ := dp.Attributes.Value("outcome")
+					durations[operation.AsString()+" "+outcome.AsString()] += dp.Count
+				}
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					direction, _ := dp.Attributes.Value("direction")
+					bytes[direction.AsString()] += dp.Value
+				}
+			}
+		}
+	}
+	for key, want := range map[string]uint64{"put ok": 1, "get ok": 1, "stat not_found": 1} {
+		if durations[key] != want {
+			t.Errorf("blobstore.operation.duration %s = %d, want %d", key, durations[key], want)
+		}
+	}
+	if bytes["out"] != 5 || bytes["in"] != 5 {
+		t.Errorf("blobstore.bytes = %v, want 5 out and 5 in", bytes)
+	}
+}
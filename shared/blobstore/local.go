@@ -0,0 +1,306 @@
+package blobstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	localDefaultPageSize = 1000
+	// localAttrsDir holds the sidecar JSON files with object attributes so they
+	// don't show up as objects when a bucket directory is listed.
+	localAttrsDir = ".attrs"
+)
+
+// Local is a Store backed by a directory on the local filesystem.
+// Each bucket is a subdirectory of the root and each object is a file in it.
+type Local struct {
+	root string
+	// mu serializes writes so conditional puts are atomic within the process.
+	mu sync.Mutex
+}
+
+// localAttrs is the sidecar file stored for every object.
+type localAttrs struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Generation  int64             `json:"generation"`
+	Created     time.Time         `json:"created"`
+}
+
+// NewLocal creates a Store rooted at dir, creating the directory if needed.
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %v", err)
+	}
+	return &Local{root: dir}, nil
+}
+
+// Path returns the file path an object is stored at. Names come from requests and
+// events, so a name that would land outside the bucket's directory once cleaned, or
+// a bucket that isn't a single directory, is rejected with ErrInvalidName.
+func (l *Local) Path(bucket, name string) (string, error) {
+	bucketDir, err := l.bucketDir(bucket)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(bucketDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(bucketDir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: object %q is outside bucket %s", ErrInvalidName, name, bucket)
+	}
+	return path, nil
+}
+
+// bucketDir returns the directory a bucket is stored in.
+func (l *Local) bucketDir(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || bucket == localAttrsDir || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("%w: bucket %q", ErrInvalidName, bucket)
+	}
+	return filepath.Join(l.root, bucket), nil
+}
+
+// attrsPath returns the sidecar path for an object whose name Path has accepted.
+func (l *Local) attrsPath(bucket, name string) string {
+	return filepath.Join(l.root, localAttrsDir, bucket, filepath.Clean(filepath.FromSlash(name))+".json")
+}
+
+func (l *Local) Get(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	path, err := l.Path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, localError(err)
+	}
+	return file, nil
+}
+
+func (l *Local) Put(ctx context.Context, bucket, name string, r io.Reader, opts *PutOptions) (*Attrs, error) {
+	if opts == nil {
+		opts = &PutOptions{}
+	}
+	path, err := l.Path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %v", err)
+	}
+
+	// Stream into a temporary file first so readers never see a partial object
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("os.CreateTemp: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("io.Copy: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("tmp.Close: %v", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, err := l.Stat(ctx, bucket, name)
+	if err != nil && !errors.Is(err, ErrNotExist) {
+		return nil, err
+	}
+	if opts.IfNotExists && current != nil {
+		return nil, fmt.Errorf("%w: %s/%s already exists", ErrPreconditionFailed, bucket, name)
+	}
+	if opts.IfGenerationMatch != 0 && (current == nil || current.Generation != opts.IfGenerationMatch) {
+		return nil, fmt.Errorf("%w: %s/%s generation does not match %d", ErrPreconditionFailed, bucket, name, opts.IfGenerationMatch)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("os.Rename: %v", err)
+	}
+
+	now := time.Now()
+	sidecar := localAttrs{
+		ContentType: opts.ContentType,
+		Metadata:    opts.Metadata,
+		Generation:  now.UnixNano(),
+		Created:     now,
+	}
+	if err := l.writeAttrs(bucket, name, &sidecar); err != nil {
+		return nil, err
+	}
+	return l.Stat(ctx, bucket, name)
+}
+
+func (l *Local) writeAttrs(bucket, name string, sidecar *localAttrs) error {
+	path := l.attrsPath(bucket, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("os.MkdirAll: %v", err)
+	}
+	data, err := json.Marshal(sidecar)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("os.WriteFile: %v", err)
+	}
+	return nil
+}
+
+func (l *Local) List(ctx context.Context, bucket string, opts *ListOptions) (*Page, error) {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	pageSize := opts.PageSize
+	if pageSize <= 0 {
+		pageSize = localDefaultPageSize
+	}
+
+	bucketDir, err := l.bucketDir(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	err = filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, opts.Prefix) && name > opts.PageToken {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("filepath.WalkDir: %v", err)
+	}
+	sort.Strings(names)
+
+	page := &Page{}
+	if len(names) > pageSize {
+		names = names[:pageSize]
+		page.NextPageToken = names[len(names)-1]
+	}
+	for _, name := range names {
+		attrs, err := l.Stat(ctx, bucket, name)
+		if errors.Is(err, ErrNotExist) {
+			// Deleted while we were listing
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		page.Objects = append(page.Objects, attrs)
+	}
+	return page, nil
+}
+
+func (l *Local) Delete(ctx context.Context, bucket, name string) error {
+	path, err := l.Path(bucket, name)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		return localError(err)
+	}
+	os.Remove(l.attrsPath(bucket, name))
+	return nil
+}
+
+func (l *Local) Stat(ctx context.Context, bucket, name string) (*Attrs, error) {
+	path, err := l.Path(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, localError(err)
+	}
+
+	// Objects copied into the directory by hand have no sidecar; fall back to the file's mtime
+	sidecar := localAttrs{
+		Generation: info.ModTime().UnixNano(),
+		Created:    info.ModTime(),
+	}
+	if data, err := os.ReadFile(l.attrsPath(bucket, name)); err == nil {
+		if err := json.Unmarshal(data, &sidecar); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %v", err)
+		}
+	}
+
+	return &Attrs{
+		Bucket:      bucket,
+		Name:        name,
+		Size:        info.Size(),
+		ContentType: sidecar.ContentType,
+		Created:     sidecar.Created,
+		Updated:     info.ModTime(),
+		Generation:  sidecar.Generation,
+		Metadata:    sidecar.Metadata,
+	}, nil
+}
+
+func (l *Local) Update(ctx context.Context, bucket, name string, metadata map[string]string) (*Attrs, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	current, err := l.Stat(ctx, bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	merged := make(map[string]string, len(current.Metadata)+len(metadata))
+	for k, v := range current.Metadata {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		if v == "" {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	sidecar := localAttrs{
+		ContentType: current.ContentType,
+		Metadata:    merged,
+		Generation:  current.Generation,
+		Created:     current.Created,
+	}
+	if err := l.writeAttrs(bucket, name, &sidecar); err != nil {
+		return nil, err
+	}
+	return l.Stat(ctx, bucket, name)
+}
+
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrNotExist, err)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalPutGetStat(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &PutOptions{ContentType: "video/mp4", Metadata: map[string]string{"uploader": "someone"}}
+	if _, err := store.Put(ctx, "quarantine", "clips/a.mp4", strings.NewReader("video"), opts); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	r, err := store.Get(ctx, "quarantine", "clips/a.mp4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "video" {
+		t.Errorf("Get = %q, want %q", data, "video")
+	}
+
+	attrs, err := store.Stat(ctx, "quarantine", "clips/a.mp4")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if attrs.Size != 5 || attrs.ContentType != "video/mp4" || attrs.Metadata["uploader"] != "someone" {
+		t.Errorf("Stat = %+v", attrs)
+	}
+
+	page, err := store.List(ctx, "quarantine", nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(page.Objects) != 1 || page.Objects[0].Name != "clips/a.mp4" {
+		t.Errorf("List = %+v, want clips/a.mp4 only", page.Objects)
+	}
+
+	if err := store.Delete(ctx, "quarantine", "clips/a.mp4"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Stat(ctx, "quarantine", "clips/a.mp4"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat after Delete = %v, want ErrNotExist", err)
+	}
+}
+
+func TestLocalPreconditions(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Put(ctx, "b", "a.mp4", strings.NewReader("1"), &PutOptions{IfNotExists: true})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := store.Put(ctx, "b", "a.mp4", strings.NewReader("2"), &PutOptions{IfNotExists: true}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("second IfNotExists Put = %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := store.Put(ctx, "b", "a.mp4", strings.NewReader("2"), &PutOptions{IfGenerationMatch: first.Generation + 1}); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("Put with a stale generation = %v, want ErrPreconditionFailed", err)
+	}
+	if _, err := store.Put(ctx, "b", "a.mp4", strings.NewReader("2"), &PutOptions{IfGenerationMatch: first.Generation}); err != nil {
+		t.Errorf("Put with the current generation: %v", err)
+	}
+}
+
+func TestLocalRejectsNamesOutsideTheBucket(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	store, err := NewLocal(filepath.Join(root, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"../escaped.mp4", "../../escaped.mp4", "a/../../escaped.mp4", "..", ".", ""} {
+		if _, err := store.Put(ctx, "quarantine", name, strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if _, err := store.Get(ctx, "quarantine", name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidName", name, err)
+		}
+		if err := store.Delete(ctx, "quarantine", name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+	for _, bucket := range []string{"..", "a/b", ".attrs", ""} {
+		if _, err := store.Put(ctx, bucket, "a.mp4", strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Put to bucket %q = %v, want ErrInvalidName", bucket, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.mp4")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the store: %v", err)
+	}
+
+	// Names that stay inside the bucket once cleaned are fine
+	if _, err := store.Put(ctx, "quarantine", "a/../b.mp4", strings.NewReader("x"), nil); err != nil {
+		t.Errorf("Put(a/../b.mp4): %v", err)
+	}
+	if _, err := store.Stat(ctx, "quarantine", "b.mp4"); err != nil {
+		t.Errorf("Stat(b.mp4): %v", err)
+	}
+}
@@ -0,0 +1,617 @@
+// Package config loads and validates the settings for every service from
+// environment variables and an optional YAML or JSON file.
+//
+// Values are read from the file named by CONFIG_FILE first, then environment
+// variables override them. Load validates only the sections the calling
+// service uses and reports every problem at once so a bad deploy fails at
+// startup instead of on the first request.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+	"gopkg.in/yaml.v3"
+)
+
+// Service identifies which service is loading the configuration.
+type Service string
+
+const (
+	ServiceDownload    Service = "download"
+	ServiceNormalize   Service = "normalize"
+	ServiceConcatenate Service = "concatenate"
+	ServiceDiscord     Service = "discord"
+	// ServicePipeline is the local runner that chains download, normalize and concatenate.
+	ServicePipeline Service = "pipeline"
+)
+
+// Defaults match the production project so existing deploys keep working without a config file.
+const (
+	DefaultQuarantineBucket   = "videos-quarantine-2486aa1dcdb442fda0c2f090761b4479"
+	DefaultNormalizedBucket   = "videos-normalized-3ec32eeafcfe42f28cb86296afa48673"
+	DefaultCompilationsBucket = "compilations-f714ffc72eaf414ea0f51b18f4678383"
+	DefaultMinVideos          = 30
+	DefaultYtdlpPath          = "/usr/local/bin/yt-dlp"
+	// DefaultYtdlpFormat prefers mp4 video with m4a audio, falling back to the best single mp4.
+	DefaultYtdlpFormat = "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4]"
+	// DefaultYtdlpOutputTemplate names files platform-identifier.ext, e.g. youtube-BaWjenozKc.mp4.
+	DefaultYtdlpOutputTemplate = "%(extractor)s-%(id)s.%(ext)s"
+	// DefaultAPIURL is the Meme Compiler API, the same one its client uses by default.
+	DefaultAPIURL = "https://mc-api-b473pkndcq-uk.a.run.app"
+)
+
+// Secret is a string that is redacted whenever it is printed or marshalled.
+type Secret string
+
+const redacted = "[REDACTED]"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Value returns the unredacted secret.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+type Config struct {
+	Buckets     Buckets     `json:"buckets" yaml:"buckets"`
+	Download    Download    `json:"download" yaml:"download"`
+	Normalize   Normalize   `json:"normalize" yaml:"normalize"`
+	Concatenate Concatenate `json:"concatenate" yaml:"concatenate"`
+	Discord     Discord     `json:"discord" yaml:"discord"`
+	// FFmpeg applies to every ffmpeg run in normalize and concatenate.
+	FFmpeg FFmpeg `json:"ffmpeg" yaml:"ffmpeg"`
+	// CleanupReserve is how many seconds before a deadline the work is stopped, leaving
+	// time to upload the result or record the failure before the instance is killed.
+	CleanupReserve float64 `json:"cleanupReserve" yaml:"cleanupReserve"`
+	// DebugEndpoints exposes the effective configuration at /debug/config.
+	DebugEndpoints bool `json:"debugEndpoints" yaml:"debugEndpoints"`
+}
+
+type Buckets struct {
+	Quarantine string `json:"quarantine" yaml:"quarantine"`
+	Normalized string `json:"normalized" yaml:"normalized"`
+	// Fingerprints holds the dedupe index. Only required when dedupe is enabled.
+	Fingerprints string `json:"fingerprints" yaml:"fingerprints"`
+	Compilations string `json:"compilations" yaml:"compilations"`
+	// Failed holds videos normalize gave up on. Empty keeps them in the bucket they
+	// came from, under the failed/ prefix.
+	Failed string `json:"failed" yaml:"failed"`
+}
+
+type Download struct {
+	YtdlpPath string `json:"ytdlpPath" yaml:"ytdlpPath"`
+	// Format is the yt-dlp format selector.
+	Format string `json:"format" yaml:"format"`
+	// OutputTemplate is the yt-dlp output template for the file name, without a directory.
+	OutputTemplate string `json:"outputTemplate" yaml:"outputTemplate"`
+	// MaxFilesize is the largest download in bytes. Zero is unlimited.
+	MaxFilesize int64 `json:"maxFilesize" yaml:"maxFilesize"`
+	// MaxDuration is the longest video in seconds. Zero is unlimited.
+	MaxDuration float64 `json:"maxDuration" yaml:"maxDuration"`
+	// CookiesFile is a Netscape format cookies file passed to yt-dlp, for sites that need a login.
+	CookiesFile string `json:"cookiesFile" yaml:"cookiesFile"`
+	// RateLimit caps the download rate, in yt-dlp's format, e.g. 2M. Empty is unlimited.
+	RateLimit string `json:"rateLimit" yaml:"rateLimit"`
+	// CheckCertificates verifies TLS certificates. Off by default, which passes --no-check-certificates.
+	CheckCertificates bool `json:"checkCertificates" yaml:"checkCertificates"`
+	// Proxy is set from the PROXY_* variables. Its URL may list several proxies separated
+	// by commas, which share its credentials.
+	Proxy Proxy `json:"proxy" yaml:"proxy"`
+	// Proxies are tried after the ones in Proxy, each with its own credentials.
+	Proxies       []Proxy `json:"proxies" yaml:"proxies"`
+	WebhookSecret Secret  `json:"webhookSecret" yaml:"webhookSecret"`
+	// JobsDir keeps job state as files in this directory.
+	JobsDir string `json:"jobsDir" yaml:"jobsDir"`
+	// JobsBucket keeps job state as objects in this bucket. With neither it nor JobsDir
+	// set, job state is kept in memory and only the instance that took the job knows it.
+	JobsBucket string `json:"jobsBucket" yaml:"jobsBucket"`
+	// JobRetention is how many seconds the in-memory store keeps a finished job.
+	JobRetention float64 `json:"jobRetention" yaml:"jobRetention"`
+	// JobTimeout is how many seconds a job may run, including the webhook.
+	JobTimeout float64 `json:"jobTimeout" yaml:"jobTimeout"`
+}
+
+type Proxy struct {
+	User     string `json:"user" yaml:"user"`
+	Password Secret `json:"password" yaml:"password"`
+	// URL is the proxy host and port, without credentials. An http, https, socks5 or
+	// socks5h scheme may be given and http is assumed without one.
+	URL string `json:"url" yaml:"url"`
+}
+
+// proxySchemes are the proxy schemes yt-dlp supports.
+var proxySchemes = map[string]bool{"http": true, "https": true, "socks5": true, "socks5h": true}
+
+// ParseURL returns the proxy URL with the credentials attached, escaped as needed.
+// The result contains the password, so it must not be logged.
+func (p *Proxy) ParseURL() (*url.URL, error) {
+	raw := p.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid proxy URL", p.URL)
+	}
+	if !proxySchemes[u.Scheme] {
+		return nil, fmt.Errorf("%q has unsupported scheme %q, use http, https, socks5 or socks5h", p.URL, u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%q must not include credentials, set the user and password separately", u.Redacted())
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("%q must include a host and port", p.URL)
+	}
+	if (p.User == "") != (p.Password == "") {
+		return nil, fmt.Errorf("%q needs both a user and a password, or neither", p.URL)
+	}
+	if p.User != "" {
+		u.User = url.UserPassword(p.User, p.Password.Value())
+	}
+	return &url.URL{Scheme: u.Scheme, User: u.User, Host: u.Host}, nil
+}
+
+// ProxyPool returns every configured proxy in order: one for each URL listed in Proxy,
+// then Proxies. An empty pool means downloads connect directly.
+func (d *Download) ProxyPool() []Proxy {
+	var pool []Proxy
+	for _, u := range strings.Split(d.Proxy.URL, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			pool = append(pool, Proxy{User: d.Proxy.User, Password: d.Proxy.Password, URL: u})
+		}
+	}
+	return append(pool, d.Proxies...)
+}
+
+type Normalize struct {
+	Dedupe Dedupe `json:"dedupe" yaml:"dedupe"`
+	// MaxAttempts is how many times a video is tried before it is moved to the failed
+	// bucket. Videos ffprobe rejects are moved on the first attempt.
+	MaxAttempts int `json:"maxAttempts" yaml:"maxAttempts"`
+	// Timeout is how many seconds an event may take. It should match the function's timeout.
+	Timeout float64 `json:"timeout" yaml:"timeout"`
+	// Profile is the profile videos are normalized to unless their metadata picks another.
+	Profile string `json:"profile" yaml:"profile"`
+	// Fill is how the rest of the frame is filled unless a video's metadata picks another.
+	Fill string `json:"fill" yaml:"fill"`
+	// Preset is the encoder speed and quality tier.
+	Preset string `json:"preset" yaml:"preset"`
+	// MaxClipLength cuts clips longer than this many seconds down to their first
+	// MaxClipLength seconds, or that long from the start the submitter picked. Zero is unlimited.
+	MaxClipLength float64 `json:"maxClipLength" yaml:"maxClipLength"`
+}
+
+// Dedupe actions.
+const (
+	// DedupeActionSkip drops a near-duplicate instead of publishing it.
+	DedupeActionSkip = "skip"
+	// DedupeActionFlag publishes a near-duplicate with metadata naming the original,
+	// and concatenate leaves it out of compilations.
+	DedupeActionFlag = "flag"
+)
+
+// Dedupe configures near-duplicate detection after normalization.
+type Dedupe struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Threshold is the picture similarity at which a clip is a near-duplicate,
+	// from 0.5 for unrelated clips to 1 for identical ones.
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	// AudioThreshold is the sound similarity that must also be reached when both clips have audio.
+	AudioThreshold float64 `json:"audioThreshold" yaml:"audioThreshold"`
+	Action         string  `json:"action" yaml:"action"`
+	// WindowDays limits the comparison to clips indexed in the last WindowDays days. Zero compares against every clip.
+	WindowDays int `json:"windowDays" yaml:"windowDays"`
+}
+
+// Concatenate modes.
+const (
+	// ConcatModeCopy joins clips with the concat demuxer and -c copy. Fast, hard cuts only.
+	ConcatModeCopy = "copy"
+	// ConcatModeReencode joins clips with a filter_complex graph, which allows transitions.
+	ConcatModeReencode = "reencode"
+)
+
+type Concatenate struct {
+	MinVideos  int        `json:"minVideos" yaml:"minVideos"`
+	Mode       string     `json:"mode" yaml:"mode"`
+	Transition Transition `json:"transition" yaml:"transition"`
+	// Attribution overlays and title cards require reencode mode and switch to it when used.
+	Attribution Attribution `json:"attribution" yaml:"attribution"`
+	Selection   Selection   `json:"selection" yaml:"selection"`
+	// Workers is how many clips are downloaded at once.
+	Workers int `json:"workers" yaml:"workers"`
+	// UploadChunkSize is the resumable upload chunk size for the compilation in bytes.
+	UploadChunkSize int `json:"uploadChunkSize" yaml:"uploadChunkSize"`
+	// WorkspaceBudget caps the scratch space a compilation may use in bytes.
+	// Zero uses the free space of the temp directory, which is memory on Cloud Functions.
+	WorkspaceBudget int64 `json:"workspaceBudget" yaml:"workspaceBudget"`
+	// Timeout is how many seconds a request may take. It should match the function's timeout.
+	Timeout float64 `json:"timeout" yaml:"timeout"`
+	// Profile picks the clips for a compilation unless the request picks another.
+	// Clips normalized before profiles existed are landscape.
+	Profile string `json:"profile" yaml:"profile"`
+	// Preset is the encoder speed and quality tier in reencode mode.
+	Preset string `json:"preset" yaml:"preset"`
+}
+
+// Selection decides which normalized objects go into a compilation. Eligible objects
+// are taken oldest first until MaxClips or MaxDuration is reached.
+type Selection struct {
+	// Extensions are the object name suffixes treated as videos, along with any video/* content type.
+	Extensions []string `json:"extensions" yaml:"extensions"`
+	// MinSize and MaxSize bound the object size in bytes. Zero MaxSize is unlimited.
+	MinSize int64 `json:"minSize" yaml:"minSize"`
+	MaxSize int64 `json:"maxSize" yaml:"maxSize"`
+	// MaxClips caps the number of clips in one compilation.
+	MaxClips int `json:"maxClips" yaml:"maxClips"`
+	// MaxDuration caps the total clip length in seconds. Zero is unlimited.
+	MaxDuration float64 `json:"maxDuration" yaml:"maxDuration"`
+}
+
+// Transition configures the crossfades between clips in reencode mode.
+type Transition struct {
+	// Name is an ffmpeg xfade transition, e.g. fade, dissolve or wipeleft.
+	Name string `json:"name" yaml:"name"`
+	// Duration is the video crossfade in seconds. Zero is a hard cut.
+	Duration float64 `json:"duration" yaml:"duration"`
+	// AudioDuration is the acrossfade in seconds. Zero is a hard cut.
+	AudioDuration float64 `json:"audioDuration" yaml:"audioDuration"`
+}
+
+// Attribution configures the credits burned into a compilation.
+type Attribution struct {
+	// Enabled draws a lower third crediting the uploader and submitter at the start of each clip.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Duration is how many seconds the lower third is shown.
+	Duration float64 `json:"duration" yaml:"duration"`
+	// FontFile is passed to drawtext. Empty uses fontconfig's default font.
+	FontFile string `json:"fontFile" yaml:"fontFile"`
+	FontSize int    `json:"fontSize" yaml:"fontSize"`
+	// IntroText and OutroText add a title card before the first and after the last clip when set.
+	IntroText    string  `json:"introText" yaml:"introText"`
+	OutroText    string  `json:"outroText" yaml:"outroText"`
+	CardDuration float64 `json:"cardDuration" yaml:"cardDuration"`
+}
+
+type FFmpeg struct {
+	// Timeout is how many seconds one ffmpeg run may take before it is killed.
+	Timeout float64 `json:"timeout" yaml:"timeout"`
+}
+
+// TimeoutDuration returns Timeout as a time.Duration.
+func (f *FFmpeg) TimeoutDuration() time.Duration {
+	return seconds(f.Timeout)
+}
+
+// JobTimeoutDuration returns JobTimeout as a time.Duration.
+func (d *Download) JobTimeoutDuration() time.Duration {
+	return seconds(d.JobTimeout)
+}
+
+// JobRetentionDuration returns JobRetention as a time.Duration.
+func (d *Download) JobRetentionDuration() time.Duration {
+	return seconds(d.JobRetention)
+}
+
+// TimeoutDuration returns Timeout as a time.Duration.
+func (n *Normalize) TimeoutDuration() time.Duration {
+	return seconds(n.Timeout)
+}
+
+// TimeoutDuration returns Timeout as a time.Duration.
+func (c *Concatenate) TimeoutDuration() time.Duration {
+	return seconds(c.Timeout)
+}
+
+// CleanupReserveDuration returns CleanupReserve as a time.Duration.
+func (cfg *Config) CleanupReserveDuration() time.Duration {
+	return seconds(cfg.CleanupReserve)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type Discord struct {
+	IdentityToken Secret `json:"identityToken" yaml:"identityToken"`
+	PublicKey     string `json:"publicKey" yaml:"publicKey"`
+	// APIURL is the Meme Compiler API the bot sends videos and compilations to.
+	APIURL string `json:"apiURL" yaml:"apiURL"`
+}
+
+// ValidationError lists every problem found while loading the configuration.
+type ValidationError struct {
+	Service  Service
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s configuration:\n  - %s", e.Service, strings.Join(e.Problems, "\n  - "))
+}
+
+// Default returns the configuration used when nothing is set.
+func Default() *Config {
+	return &Config{
+		Buckets: Buckets{
+			Quarantine:   DefaultQuarantineBucket,
+			Normalized:   DefaultNormalizedBucket,
+			Compilations: DefaultCompilationsBucket,
+		},
+		Download: Download{
+			YtdlpPath:      DefaultYtdlpPath,
+			Format:         DefaultYtdlpFormat,
+			OutputTemplate: DefaultYtdlpOutputTemplate,
+			MaxFilesize:    256 << 20,
+			MaxDuration:    600,
+			JobTimeout:     1800,
+			JobRetention:   86400,
+		},
+		Normalize: Normalize{
+			Dedupe: Dedupe{
+				Threshold:      0.9,
+				AudioThreshold: 0.65,
+				Action:         DedupeActionFlag,
+				WindowDays:     90,
+			},
+			MaxAttempts: 3,
+			// The longest an event-driven function may run
+			Timeout: 540,
+			Profile: profile.Default,
+			Fill:    profile.FillPad,
+			Preset:  encoder.Default,
+		},
+		Concatenate: Concatenate{
+			MinVideos: DefaultMinVideos,
+			Mode:      ConcatModeCopy,
+			Transition: Transition{
+				Name: "fade",
+			},
+			Attribution: Attribution{
+				Duration:     4,
+				FontSize:     32,
+				CardDuration: 3,
+			},
+			Selection: Selection{
+				Extensions:  []string{".mp4"},
+				MinSize:     1,
+				MaxClips:    50,
+				MaxDuration: 900,
+			},
+			Workers:         4,
+			UploadChunkSize: 8 << 20,
+			Timeout:         3600,
+			Profile:         profile.Default,
+			Preset:          encoder.Default,
+		},
+		FFmpeg: FFmpeg{
+			Timeout: 1800,
+		},
+		Discord: Discord{
+			APIURL: DefaultAPIURL,
+		},
+		CleanupReserve: 60,
+	}
+}
+
+// Load reads the configuration for service and validates it.
+func Load(service Service) (*Config, error) {
+	cfg := Default()
+	if service == ServicePipeline {
+		// Buckets are directories under the pipeline's data dir, so use short names
+		cfg.Buckets = Buckets{Quarantine: "quarantine", Normalized: "normalized", Compilations: "compilations", Fingerprints: "fingerprints"}
+	}
+	var problems []string
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := readFile(path, cfg); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	problems = append(problems, cfg.applyEnv()...)
+	problems = append(problems, cfg.validate(service)...)
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Service: service, Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readFile decodes a YAML or JSON file over cfg, rejecting unknown fields.
+func readFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("CONFIG_FILE: %v", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return fmt.Errorf("CONFIG_FILE %s: %v", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			return fmt.Errorf("CONFIG_FILE %s: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("CONFIG_FILE %s: unsupported extension, use .yaml, .yml or .json", path)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with any environment variables that are set.
+func (cfg *Config) applyEnv() []string {
+	var problems []string
+
+	envString(&cfg.Buckets.Quarantine, "QUARANTINE_BUCKET")
+	envString(&cfg.Buckets.Normalized, "NORMALIZED_BUCKET")
+	envString(&cfg.Buckets.Compilations, "COMPILATIONS_BUCKET")
+	envString(&cfg.Buckets.Fingerprints, "FINGERPRINTS_BUCKET")
+	envString(&cfg.Buckets.Failed, "FAILED_BUCKET")
+
+	envString(&cfg.Download.YtdlpPath, "YTDLP_PATH")
+	envString(&cfg.Download.Format, "YTDLP_FORMAT")
+	envString(&cfg.Download.OutputTemplate, "YTDLP_OUTPUT_TEMPLATE")
+	problems = append(problems, envInt64(&cfg.Download.MaxFilesize, "MAX_FILESIZE")...)
+	problems = append(problems, envFloat(&cfg.Download.MaxDuration, "MAX_VIDEO_DURATION")...)
+	envString(&cfg.Download.CookiesFile, "COOKIES_FILE")
+	envString(&cfg.Download.RateLimit, "RATE_LIMIT")
+	problems = append(problems, envBool(&cfg.Download.CheckCertificates, "CHECK_CERTIFICATES")...)
+	envString(&cfg.Download.Proxy.User, "PROXY_USER")
+	envSecret(&cfg.Download.Proxy.Password, "PROXY_PASSWORD")
+	envString(&cfg.Download.Proxy.URL, "PROXY_URL")
+	envSecret(&cfg.Download.WebhookSecret, "WEBHOOK_SECRET")
+	envString(&cfg.Download.JobsDir, "JOBS_DIR")
+	envString(&cfg.Download.JobsBucket, "JOBS_BUCKET")
+	problems = append(problems, envFloat(&cfg.Download.JobRetention, "JOB_RETENTION")...)
+	problems = append(problems, envFloat(&cfg.Download.JobTimeout, "JOB_TIMEOUT")...)
+
+	problems = append(problems, envBool(&cfg.Normalize.Dedupe.Enabled, "DEDUPE")...)
+	problems = append(problems, envFloat(&cfg.Normalize.Dedupe.Threshold, "DEDUPE_THRESHOLD")...)
+	problems = append(problems, envFloat(&cfg.Normalize.Dedupe.AudioThreshold, "DEDUPE_AUDIO_THRESHOLD")...)
+	envString(&cfg.Normalize.Dedupe.Action, "DEDUPE_ACTION")
+	problems = append(problems, envInt(&cfg.Normalize.Dedupe.WindowDays, "DEDUPE_WINDOW_DAYS")...)
+	problems = append(problems, envInt(&cfg.Normalize.MaxAttempts, "NORMALIZE_MAX_ATTEMPTS")...)
+	problems = append(problems, envFloat(&cfg.Normalize.Timeout, "NORMALIZE_TIMEOUT")...)
+	envString(&cfg.Normalize.Profile, "NORMALIZE_PROFILE")
+	envString(&cfg.Normalize.Fill, "NORMALIZE_FILL")
+	envString(&cfg.Normalize.Preset, "NORMALIZE_PRESET")
+	problems = append(problems, envFloat(&cfg.Normalize.MaxClipLength, "MAX_CLIP_LENGTH")...)
+
+	problems = append(problems, envInt(&cfg.Concatenate.MinVideos, "MIN_VIDEOS")...)
+	envString(&cfg.Concatenate.Mode, "CONCAT_MODE")
+	envString(&cfg.Concatenate.Transition.Name, "TRANSITION")
+	problems = append(problems, envFloat(&cfg.Concatenate.Transition.Duration, "CROSSFADE_DURATION")...)
+	problems = append(problems, envFloat(&cfg.Concatenate.Transition.AudioDuration, "AUDIO_CROSSFADE_DURATION")...)
+	problems = append(problems, envBool(&cfg.Concatenate.Attribution.Enabled, "ATTRIBUTION")...)
+	problems = append(problems, envFloat(&cfg.Concatenate.Attribution.Duration, "ATTRIBUTION_DURATION")...)
+	envString(&cfg.Concatenate.Attribution.FontFile, "FONT_FILE")
+	problems = append(problems, envInt(&cfg.Concatenate.Attribution.FontSize, "FONT_SIZE")...)
+	envString(&cfg.Concatenate.Attribution.IntroText, "INTRO_TEXT")
+	envString(&cfg.Concatenate.Attribution.OutroText, "OUTRO_TEXT")
+	problems = append(problems, envFloat(&cfg.Concatenate.Attribution.CardDuration, "CARD_DURATION")...)
+	envList(&cfg.Concatenate.Selection.Extensions, "CLIP_EXTENSIONS")
+	problems = append(problems, envInt64(&cfg.Concatenate.Selection.MinSize, "MIN_CLIP_SIZE")...)
+	problems = append(problems, envInt64(&cfg.Concatenate.Selection.MaxSize, "MAX_CLIP_SIZE")...)
+	problems = append(problems, envInt(&cfg.Concatenate.Selection.MaxClips, "MAX_CLIPS")...)
+	problems = append(problems, envFloat(&cfg.Concatenate.Selection.MaxDuration, "MAX_DURATION")...)
+	problems = append(problems, envInt(&cfg.Concatenate.Workers, "CONCAT_WORKERS")...)
+	problems = append(problems, envInt(&cfg.Concatenate.UploadChunkSize, "UPLOAD_CHUNK_SIZE")...)
+	problems = append(problems, envInt64(&cfg.Concatenate.WorkspaceBudget, "WORKSPACE_BUDGET")...)
+	problems = append(problems, envFloat(&cfg.Concatenate.Timeout, "CONCATENATE_TIMEOUT")...)
+	envString(&cfg.Concatenate.Profile, "CONCAT_PROFILE")
+	envString(&cfg.Concatenate.Preset, "CONCAT_PRESET")
+
+	problems = append(problems, envFloat(&cfg.FFmpeg.Timeout, "FFMPEG_TIMEOUT")...)
+	problems = append(problems, envFloat(&cfg.CleanupReserve, "CLEANUP_RESERVE")...)
+
+	envSecret(&cfg.Discord.IdentityToken, "IDENTITY_TOKEN")
+	envString(&cfg.Discord.PublicKey, "DISCORD_PUBLIC_KEY")
+	envString(&cfg.Discord.APIURL, "MEME_COMPILER_API_URL")
+
+	problems = append(problems, envBool(&cfg.DebugEndpoints, "DEBUG_ENDPOINTS")...)
+
+	return problems
+}
+
+func envString(field *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*field = value
+	}
+}
+
+func envSecret(field *Secret, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*field = Secret(value)
+	}
+}
+
+func envInt(field *int, name string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %q is not an integer", name, value)}
+	}
+	*field = n
+	return nil
+}
+
+// envList splits a comma separated variable, ignoring empty entries.
+func envList(field *[]string, name string) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*field = list
+}
+
+func envInt64(field *int64, name string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %q is not an integer", name, value)}
+	}
+	*field = n
+	return nil
+}
+
+func envFloat(field *float64, name string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %q is not a number", name, value)}
+	}
+	*field = f
+	return nil
+}
+
+func envBool(field *bool, name string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return []string{fmt.Sprintf("%s: %q is not a boolean", name, value)}
+	}
+	*field = b
+	return nil
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Handler serves the effective configuration as JSON with secrets redacted.
+func Handler(cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cfg); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to encode configuration", "error", err)
+		}
+	}
+}
+
+// String returns the configuration as JSON with secrets redacted, for startup logs.
+func (cfg *Config) String() string {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err.Error()
+	}
+	return string(data)
+}
@@ -0,0 +1,321 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+// bucketNamePattern follows the Cloud Storage naming rules for buckets without dots.
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{1,61}[a-z0-9]$`)
+
+// validate returns a problem for every setting service needs that is missing or malformed.
+func (cfg *Config) validate(service Service) []string {
+	var problems []string
+
+	switch service {
+	case ServiceDownload:
+		problems = append(problems, validateBucket("QUARANTINE_BUCKET", cfg.Buckets.Quarantine)...)
+		problems = append(problems, cfg.Download.validate()...)
+		problems = append(problems, cfg.validateTimeout("JOB_TIMEOUT", cfg.Download.JobTimeout)...)
+	case ServiceNormalize:
+		problems = append(problems, validateBucket("NORMALIZED_BUCKET", cfg.Buckets.Normalized)...)
+		problems = append(problems, cfg.Normalize.validate(cfg.Buckets)...)
+		problems = append(problems, cfg.FFmpeg.validate()...)
+		problems = append(problems, cfg.validateTimeout("NORMALIZE_TIMEOUT", cfg.Normalize.Timeout)...)
+	case ServiceConcatenate:
+		problems = append(problems, validateBucket("NORMALIZED_BUCKET", cfg.Buckets.Normalized)...)
+		problems = append(problems, validateBucket("COMPILATIONS_BUCKET", cfg.Buckets.Compilations)...)
+		problems = append(problems, cfg.Concatenate.validate()...)
+		problems = append(problems, cfg.FFmpeg.validate()...)
+		problems = append(problems, cfg.validateTimeout("CONCATENATE_TIMEOUT", cfg.Concatenate.Timeout)...)
+	case ServicePipeline:
+		// Runs every video stage locally
+		problems = append(problems, validateBucket("QUARANTINE_BUCKET", cfg.Buckets.Quarantine)...)
+		problems = append(problems, validateBucket("NORMALIZED_BUCKET", cfg.Buckets.Normalized)...)
+		problems = append(problems, validateBucket("COMPILATIONS_BUCKET", cfg.Buckets.Compilations)...)
+		problems = append(problems, cfg.Download.validate()...)
+		problems = append(problems, cfg.Normalize.validate(cfg.Buckets)...)
+		problems = append(problems, cfg.Concatenate.validate()...)
+		problems = append(problems, cfg.FFmpeg.validate()...)
+		problems = append(problems, cfg.validateTimeout("JOB_TIMEOUT", cfg.Download.JobTimeout)...)
+		problems = append(problems, cfg.validateTimeout("NORMALIZE_TIMEOUT", cfg.Normalize.Timeout)...)
+		problems = append(problems, cfg.validateTimeout("CONCATENATE_TIMEOUT", cfg.Concatenate.Timeout)...)
+	case ServiceDiscord:
+		if cfg.Discord.IdentityToken == "" {
+			problems = append(problems, "IDENTITY_TOKEN: required")
+		}
+		if key, err := hex.DecodeString(cfg.Discord.PublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+			problems = append(problems, fmt.Sprintf("DISCORD_PUBLIC_KEY: must be a %d byte hex encoded ed25519 key", ed25519.PublicKeySize))
+		}
+		if u, err := url.Parse(cfg.Discord.APIURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("MEME_COMPILER_API_URL: %q must be an http or https URL", cfg.Discord.APIURL))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown service %q", service))
+	}
+
+	return problems
+}
+
+// rateLimitPattern matches yt-dlp's --limit-rate values, e.g. 500K or 4.2M.
+var rateLimitPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[KMG]?$`)
+
+func (d *Download) validate() []string {
+	var problems []string
+	if d.YtdlpPath == "" {
+		problems = append(problems, "YTDLP_PATH: required")
+	}
+	if d.Format == "" {
+		problems = append(problems, "YTDLP_FORMAT: required")
+	}
+	// The template is joined onto each job's workspace, so it must not leave it
+	if d.OutputTemplate == "" {
+		problems = append(problems, "YTDLP_OUTPUT_TEMPLATE: required")
+	} else if strings.ContainsAny(d.OutputTemplate, `/\`) || strings.Contains(d.OutputTemplate, "..") {
+		problems = append(problems, fmt.Sprintf("YTDLP_OUTPUT_TEMPLATE: %q must be a file name without a directory", d.OutputTemplate))
+	}
+	if d.MaxFilesize < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_FILESIZE: must not be negative, got %d", d.MaxFilesize))
+	}
+	if d.MaxDuration < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_VIDEO_DURATION: must not be negative, got %g", d.MaxDuration))
+	}
+	if d.CookiesFile != "" {
+		if _, err := os.Stat(d.CookiesFile); err != nil {
+			problems = append(problems, fmt.Sprintf("COOKIES_FILE: %v", err))
+		}
+	}
+	if d.RateLimit != "" && !rateLimitPattern.MatchString(d.RateLimit) {
+		problems = append(problems, fmt.Sprintf("RATE_LIMIT: %q must be a number of bytes per second with an optional K, M or G suffix", d.RateLimit))
+	}
+	// The proxy is optional; without one downloads connect directly
+	if d.Proxy.URL == "" && (d.Proxy.User != "" || d.Proxy.Password != "") {
+		problems = append(problems, "PROXY_URL: required when PROXY_USER or PROXY_PASSWORD is set")
+	}
+	for i, proxy := range d.ProxyPool() {
+		if _, err := proxy.ParseURL(); err != nil {
+			problems = append(problems, fmt.Sprintf("proxy %d: %v", i+1, err))
+		}
+	}
+	if d.JobsBucket != "" {
+		problems = append(problems, validateBucket("JOBS_BUCKET", d.JobsBucket)...)
+		if d.JobsDir != "" {
+			problems = append(problems, "JOBS_BUCKET: set only one of JOBS_BUCKET and JOBS_DIR")
+		}
+	}
+	if d.JobRetention <= 0 {
+		problems = append(problems, fmt.Sprintf("JOB_RETENTION: must be positive, got %g", d.JobRetention))
+	}
+	return problems
+}
+
+func (n *Normalize) validate(buckets Buckets) []string {
+	var problems []string
+	// Failed videos are listed and requeued from the quarantine bucket without a bucket of their own
+	if buckets.Failed != "" {
+		problems = append(problems, validateBucket("FAILED_BUCKET", buckets.Failed)...)
+	} else {
+		problems = append(problems, validateBucket("QUARANTINE_BUCKET", buckets.Quarantine)...)
+	}
+	if n.MaxAttempts < 1 || n.MaxAttempts > 20 {
+		problems = append(problems, fmt.Sprintf("NORMALIZE_MAX_ATTEMPTS: must be between 1 and 20, got %d", n.MaxAttempts))
+	}
+	if _, err := profile.Lookup(n.Profile); err != nil {
+		problems = append(problems, "NORMALIZE_PROFILE: "+err.Error())
+	}
+	if err := profile.ValidateFill(n.Fill); err != nil {
+		problems = append(problems, "NORMALIZE_FILL: "+err.Error())
+	}
+	if _, err := encoder.Lookup(n.Preset); err != nil {
+		problems = append(problems, "NORMALIZE_PRESET: "+err.Error())
+	}
+	if n.MaxClipLength < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_CLIP_LENGTH: must not be negative, got %g", n.MaxClipLength))
+	}
+
+	d := n.Dedupe
+	if !d.Enabled {
+		return problems
+	}
+	problems = append(problems, validateBucket("FINGERPRINTS_BUCKET", buckets.Fingerprints)...)
+	// Unrelated clips already score around 0.5, so anything lower would match everything
+	if d.Threshold <= 0.5 || d.Threshold > 1 {
+		problems = append(problems, fmt.Sprintf("DEDUPE_THRESHOLD: must be above 0.5 and at most 1, got %g", d.Threshold))
+	}
+	if d.AudioThreshold < 0.5 || d.AudioThreshold > 1 {
+		problems = append(problems, fmt.Sprintf("DEDUPE_AUDIO_THRESHOLD: must be between 0.5 and 1, got %g", d.AudioThreshold))
+	}
+	if d.Action != DedupeActionSkip && d.Action != DedupeActionFlag {
+		problems = append(problems, fmt.Sprintf("DEDUPE_ACTION: %q must be %q or %q", d.Action, DedupeActionSkip, DedupeActionFlag))
+	}
+	if d.WindowDays < 0 {
+		problems = append(problems, fmt.Sprintf("DEDUPE_WINDOW_DAYS: must not be negative, got %d", d.WindowDays))
+	}
+	return problems
+}
+
+func (f *FFmpeg) validate() []string {
+	if f.Timeout <= 0 {
+		return []string{fmt.Sprintf("FFMPEG_TIMEOUT: must be more than 0 seconds, got %g", f.Timeout)}
+	}
+	return nil
+}
+
+// validateTimeout checks a service's timeout leaves time for the work after CleanupReserve.
+func (cfg *Config) validateTimeout(name string, timeout float64) []string {
+	if timeout <= 0 {
+		return []string{fmt.Sprintf("%s: must be more than 0 seconds, got %g", name, timeout)}
+	}
+	if cfg.CleanupReserve < 0 || cfg.CleanupReserve >= timeout {
+		return []string{fmt.Sprintf("CLEANUP_RESERVE: must be at least 0 and less than %s %g, got %g", name, timeout, cfg.CleanupReserve)}
+	}
+	return nil
+}
+
+func (c *Concatenate) validate() []string {
+	var problems []string
+	if c.MinVideos < 1 {
+		problems = append(problems, fmt.Sprintf("MIN_VIDEOS: must be at least 1, got %d", c.MinVideos))
+	}
+	if err := ValidateConcatMode(c.Mode); err != nil {
+		problems = append(problems, "CONCAT_MODE: "+err.Error())
+	}
+	for _, err := range c.Transition.Validate() {
+		problems = append(problems, err.Error())
+	}
+	problems = append(problems, c.Attribution.validate()...)
+	problems = append(problems, c.Selection.validate(c.MinVideos)...)
+	if c.Workers < 1 || c.Workers > 32 {
+		problems = append(problems, fmt.Sprintf("CONCAT_WORKERS: must be between 1 and 32, got %d", c.Workers))
+	}
+	// Cloud Storage rounds chunks up to 256 KiB, and a chunk is held in memory during upload
+	if c.UploadChunkSize < 256<<10 || c.UploadChunkSize > 64<<20 {
+		problems = append(problems, fmt.Sprintf("UPLOAD_CHUNK_SIZE: must be between 256 KiB and 64 MiB, got %d", c.UploadChunkSize))
+	}
+	if c.WorkspaceBudget < 0 {
+		problems = append(problems, fmt.Sprintf("WORKSPACE_BUDGET: must not be negative, got %d", c.WorkspaceBudget))
+	}
+	if _, err := profile.Lookup(c.Profile); err != nil {
+		problems = append(problems, "CONCAT_PROFILE: "+err.Error())
+	}
+	if _, err := encoder.Lookup(c.Preset); err != nil {
+		problems = append(problems, "CONCAT_PRESET: "+err.Error())
+	}
+	return problems
+}
+
+func (s *Selection) validate(minVideos int) []string {
+	var problems []string
+	if len(s.Extensions) == 0 {
+		problems = append(problems, "CLIP_EXTENSIONS: at least one extension is required")
+	}
+	for _, ext := range s.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			problems = append(problems, fmt.Sprintf("CLIP_EXTENSIONS: %q must start with a dot", ext))
+		}
+	}
+	if s.MinSize < 0 {
+		problems = append(problems, fmt.Sprintf("MIN_CLIP_SIZE: must not be negative, got %d", s.MinSize))
+	}
+	if s.MaxSize != 0 && s.MaxSize < s.MinSize {
+		problems = append(problems, fmt.Sprintf("MAX_CLIP_SIZE: %d is smaller than MIN_CLIP_SIZE %d", s.MaxSize, s.MinSize))
+	}
+	// A cap below the minimum would never let a compilation run
+	if s.MaxClips < minVideos {
+		problems = append(problems, fmt.Sprintf("MAX_CLIPS: %d is smaller than MIN_VIDEOS %d", s.MaxClips, minVideos))
+	}
+	if s.MaxDuration < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_DURATION: must not be negative, got %g", s.MaxDuration))
+	}
+	return problems
+}
+
+// maxOverlayDuration caps how long a lower third or title card is shown.
+const maxOverlayDuration = 30.0
+
+func (a *Attribution) validate() []string {
+	var problems []string
+	if a.Duration <= 0 || a.Duration > maxOverlayDuration {
+		problems = append(problems, fmt.Sprintf("ATTRIBUTION_DURATION: must be between 0 and %g seconds, got %g", maxOverlayDuration, a.Duration))
+	}
+	if a.FontSize < 8 || a.FontSize > 200 {
+		problems = append(problems, fmt.Sprintf("FONT_SIZE: must be between 8 and 200, got %d", a.FontSize))
+	}
+	if a.FontFile != "" {
+		if _, err := os.Stat(a.FontFile); err != nil {
+			problems = append(problems, fmt.Sprintf("FONT_FILE: %v", err))
+		}
+	}
+	if a.CardDuration <= 0 || a.CardDuration > maxOverlayDuration {
+		problems = append(problems, fmt.Sprintf("CARD_DURATION: must be between 0 and %g seconds, got %g", maxOverlayDuration, a.CardDuration))
+	}
+	return problems
+}
+
+// Overlays reports whether any text needs to be burned into the compilation.
+func (a *Attribution) Overlays() bool {
+	return a.Enabled || a.IntroText != "" || a.OutroText != ""
+}
+
+// MaxTransitionDuration caps crossfades so short clips aren't swallowed by the transition.
+const MaxTransitionDuration = 5.0
+
+// xfadeTransitions are the transitions supported by ffmpeg 5.1's xfade filter.
+var xfadeTransitions = map[string]bool{
+	"fade": true, "fadeblack": true, "fadewhite": true, "fadegrays": true, "dissolve": true, "distance": true,
+	"wipeleft": true, "wiperight": true, "wipeup": true, "wipedown": true,
+	"wipetl": true, "wipetr": true, "wipebl": true, "wipebr": true,
+	"slideleft": true, "slideright": true, "slideup": true, "slidedown": true,
+	"smoothleft": true, "smoothright": true, "smoothup": true, "smoothdown": true,
+	"circlecrop": true, "rectcrop": true, "circleopen": true, "circleclose": true,
+	"vertopen": true, "vertclose": true, "horzopen": true, "horzclose": true,
+	"diagtl": true, "diagtr": true, "diagbl": true, "diagbr": true,
+	"hlslice": true, "hrslice": true, "vuslice": true, "vdslice": true,
+	"radial": true, "pixelize": true, "hblur": true, "squeezeh": true, "squeezev": true, "zoomin": true,
+}
+
+// ValidateConcatMode reports whether mode is a supported concatenate mode.
+func ValidateConcatMode(mode string) error {
+	if mode != ConcatModeCopy && mode != ConcatModeReencode {
+		return fmt.Errorf("%q must be %q or %q", mode, ConcatModeCopy, ConcatModeReencode)
+	}
+	return nil
+}
+
+// Validate returns an error for every invalid transition setting. It is also used
+// to check transitions requested per compilation.
+func (t *Transition) Validate() []error {
+	var errs []error
+	if !xfadeTransitions[t.Name] {
+		errs = append(errs, fmt.Errorf("TRANSITION: %q is not an xfade transition", t.Name))
+	}
+	if t.Duration < 0 || t.Duration > MaxTransitionDuration {
+		errs = append(errs, fmt.Errorf("CROSSFADE_DURATION: must be between 0 and %g seconds, got %g", MaxTransitionDuration, t.Duration))
+	}
+	if t.AudioDuration < 0 || t.AudioDuration > MaxTransitionDuration {
+		errs = append(errs, fmt.Errorf("AUDIO_CROSSFADE_DURATION: must be between 0 and %g seconds, got %g", MaxTransitionDuration, t.AudioDuration))
+	}
+	// Both streams overlap by the video crossfade, so a longer audio crossfade would drift out of sync
+	if t.AudioDuration > t.Duration {
+		errs = append(errs, fmt.Errorf("AUDIO_CROSSFADE_DURATION: %g can't be longer than CROSSFADE_DURATION %g", t.AudioDuration, t.Duration))
+	}
+	return errs
+}
+
+func validateBucket(name, bucket string) []string {
+	if bucket == "" {
+		return []string{name + ": required"}
+	}
+	if !bucketNamePattern.MatchString(bucket) {
+		return []string{fmt.Sprintf("%s: %q is not a valid bucket name", name, bucket)}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package deadline
+
+import "os/exec"
+
+// killProcessGroup leaves the default cancellation, which kills only the command
+// itself, on platforms without process groups.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,52 @@
+// Package deadline ties external commands and storage calls to the context of the
+// request or event they serve, and splits an invocation's time between the work and
+// the cleanup after it. Cloud Functions kills an instance at its timeout without
+// warning, so ffmpeg or yt-dlp is stopped while there is still time to upload the
+// result, record a failure or tell the submitter.
+package deadline
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// waitDelay is how long to wait for a killed command's output before giving up on its pipes.
+const waitDelay = 5 * time.Second
+
+// WithTimeout returns a context that ends timeout from now, or sooner if ctx does.
+// A zero timeout leaves ctx's own deadline.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Reserve returns a context for the work that ends reserve before ctx's deadline,
+// leaving that time for whatever has to happen after the work. Without a deadline
+// it ends with ctx.
+func Reserve(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc) {
+	end, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, end.Add(-reserve))
+}
+
+// Cleanup returns a context for cleanup that has to run even after ctx is done, such
+// as recording a job's outcome. It keeps ctx's values, like the correlation ID and
+// span, and ends after reserve.
+func Cleanup(ctx context.Context, reserve time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), reserve)
+}
+
+// Command is exec.CommandContext for a command that may start its own children, like
+// yt-dlp starting ffmpeg. When ctx is done the command and everything it started are
+// killed, and waiting for its output gives up after a few seconds.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
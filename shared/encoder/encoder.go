@@ -0,0 +1,68 @@
+// Package encoder defines the speed and quality tiers clips are encoded with. Every
+// tier is libx264 on the CPU, so the output is the same wherever it runs; the tiers
+// only trade encode time against size at a similar quality.
+package encoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Preset names, fastest first.
+const (
+	Fast     = "fast"
+	Balanced = "balanced"
+	Quality  = "quality"
+	// Archival is the encode every clip used before presets existed.
+	Archival = "archival"
+)
+
+// Default is the preset used when none is configured. It is Archival so that clips
+// keep the quality they had before presets existed until a faster one is chosen.
+const Default = Archival
+
+// Preset is a libx264 speed and quality setting.
+type Preset struct {
+	Name string
+	// X264Preset is libx264's -preset. Slower presets compress better at the same CRF.
+	X264Preset string
+	// CRF is libx264's constant rate factor. Lower is better quality and larger files.
+	CRF int
+}
+
+var presets = []Preset{
+	{Name: Fast, X264Preset: "veryfast", CRF: 23},
+	{Name: Balanced, X264Preset: "medium", CRF: 21},
+	{Name: Quality, X264Preset: "slow", CRF: 21},
+	{Name: Archival, X264Preset: "veryslow", CRF: 21},
+}
+
+// Lookup returns the preset called name.
+func Lookup(name string) (Preset, error) {
+	for _, p := range presets {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Preset{}, fmt.Errorf("%q is not a preset, use %s", name, strings.Join(Names(), ", "))
+}
+
+// Names returns every preset name, fastest first.
+func Names() []string {
+	names := make([]string, len(presets))
+	for i, p := range presets {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// VideoArgs returns the ffmpeg output options that encode video with the preset.
+func (p Preset) VideoArgs() []string {
+	return []string{"-c:v", "libx264", "-preset", p.X264Preset, "-crf", strconv.Itoa(p.CRF), "-pix_fmt", "yuv420p"}
+}
+
+// AudioArgs are the ffmpeg output options every preset encodes audio with.
+func AudioArgs() []string {
+	return []string{"-c:a", "aac", "-ar", "48000", "-b:a", "384k"}
+}
@@ -0,0 +1,141 @@
+// Package ffmpeg runs ffmpeg with its stderr and progress captured, so a failure
+// says what went wrong instead of just "exit status 1".
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+)
+
+// DefaultStderrSize is how much of the end of stderr is kept when Options.StderrSize is zero.
+const DefaultStderrSize = 16 << 10
+
+// Options control a run. The zero value runs ffmpeg from the PATH until ctx is done.
+type Options struct {
+	// Binary is the ffmpeg executable. Empty runs ffmpeg from the PATH.
+	Binary string
+	// Timeout kills ffmpeg if it runs longer. Zero leaves it to ctx.
+	Timeout time.Duration
+	// StderrSize is how many bytes from the end of stderr are kept.
+	StderrSize int
+	// OnProgress is called with ffmpeg's progress reports, at most once per
+	// ProgressInterval and always for the final one.
+	OnProgress       func(Progress)
+	ProgressInterval time.Duration
+}
+
+// Result is what a successful run printed.
+type Result struct {
+	// Stderr is the end of ffmpeg's log output.
+	Stderr string
+	// Progress is the last progress report.
+	Progress Progress
+}
+
+// Error is a failed run.
+type Error struct {
+	// Err is the exit error, or the context error when ffmpeg was killed.
+	Err error
+	// ExitCode is -1 when ffmpeg didn't exit on its own.
+	ExitCode int
+	// Timeout is set when the run was killed by Options.Timeout.
+	Timeout time.Duration
+	// Stderr is the end of ffmpeg's log output, where it says what went wrong.
+	Stderr string
+	// Progress is the last progress report before ffmpeg stopped.
+	Progress Progress
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("ffmpeg: %v", e.Err)
+	if e.Timeout > 0 {
+		msg = fmt.Sprintf("ffmpeg: timed out after %s at %s", e.Timeout, e.Progress.OutTime)
+	}
+	if line := LastLine(e.Stderr); line != "" {
+		msg += ": " + line
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs ffmpeg with args, which don't need the global options Run adds: no
+// banner, no stdin, and progress written to stdout instead of stats on stderr.
+// When ctx is done or the timeout passes, ffmpeg and anything it started are
+// killed. A failure is returned as an *Error.
+func Run(ctx context.Context, args []string, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	binary := opts.Binary
+	if binary == "" {
+		binary = "ffmpeg"
+	}
+	stderrSize := opts.StderrSize
+	if stderrSize <= 0 {
+		stderrSize = DefaultStderrSize
+	}
+	parent := ctx
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	global := []string{"-hide_banner", "-nostdin", "-nostats", "-progress", "pipe:1"}
+	cmd := deadline.Command(ctx, binary, append(global, args...)...)
+
+	stderr := newRing(stderrSize)
+	progress := &progressWriter{report: opts.OnProgress, interval: opts.ProgressInterval}
+	cmd.Stdout = progress
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err == nil {
+		progress.flush()
+		return &Result{Stderr: stderr.String(), Progress: progress.last}, nil
+	}
+
+	runErr := &Error{Err: err, ExitCode: -1, Stderr: stderr.String(), Progress: progress.last}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		runErr.ExitCode = exitErr.ExitCode()
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		runErr.Err = ctxErr
+		if parent.Err() == nil && errors.Is(ctxErr, context.DeadlineExceeded) {
+			runErr.Timeout = opts.Timeout
+		}
+	}
+	return nil, runErr
+}
+
+// LastLine returns the last non-empty line of ffmpeg's output, which is usually the error.
+func LastLine(output string) string {
+	output = strings.TrimRight(output, "\r\n\t ")
+	if i := strings.LastIndexAny(output, "\r\n"); i >= 0 {
+		output = output[i+1:]
+	}
+	return strings.TrimSpace(output)
+}
+
+// Tail returns at most the last n bytes of ffmpeg's output, starting at a line
+// when the output is cut.
+func Tail(output string, n int) string {
+	if len(output) <= n {
+		return output
+	}
+	output = output[len(output)-n:]
+	if i := strings.IndexByte(output, '\n'); i >= 0 && i < len(output)-1 {
+		output = output[i+1:]
+	}
+	return strings.ToValidUTF8(output, "")
+}
@@ -0,0 +1,148 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+)
+
+// fakeFFmpeg logs its arguments and a few hundred lines to stderr, reports progress
+// on stdout, and then behaves as $FAKE_FFMPEG says: exits 1, hangs, or succeeds.
+const fakeFFmpeg = `#!/bin/sh
+echo "args: $*" >&2
+i=0
+while [ $i -lt 300 ]; do
+	echo "frame $i" >&2
+	i=$((i+1))
+done
+printf 'frame=90\nout_time_us=3000000\nprogress=continue\n'
+case "$FAKE_FFMPEG" in
+fail)
+	echo "in.mp4: Invalid data found when processing input" >&2
+	exit 1;;
+hang)
+	echo "still encoding" >&2
+	exec sleep 10;;
+esac
+printf 'progress=end\n'
+`
+
+// fakeBinary installs fakeFFmpeg behaving as mode and returns its path.
+func fakeBinary(t *testing.T, mode string) string {
+	t.Helper()
+	t.Setenv("FAKE_FFMPEG", mode)
+	return filepath.Join(testutil.Bin(t, map[string]string{"ffmpeg": fakeFFmpeg}), "ffmpeg")
+}
+
+func TestRun(t *testing.T) {
+	result, err := Run(context.Background(), []string{"-i", "in.mp4", "out.mp4"}, &Options{Binary: fakeBinary(t, "")})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(result.Stderr, "frame 299\n") {
+		t.Errorf("Stderr = %q, want the end of the log", Tail(result.Stderr, 100))
+	}
+	if !result.Progress.Done || result.Progress.Frame != 90 {
+		t.Errorf("Progress = %+v, want the final report", result.Progress)
+	}
+}
+
+func TestRunFails(t *testing.T) {
+	_, err := Run(context.Background(), []string{"-i", "in.mp4", "out.mp4"}, &Options{Binary: fakeBinary(t, "fail"), StderrSize: 256})
+	var ffErr *Error
+	if !errors.As(err, &ffErr) {
+		t.Fatalf("Run error = %v, want an *Error", err)
+	}
+	if ffErr.ExitCode != 1 || ffErr.Timeout != 0 {
+		t.Errorf("Error = %+v, want exit code 1 and no timeout", ffErr)
+	}
+	// Only the end of the log is kept, which is where the reason is
+	if len(ffErr.Stderr) > 256 || strings.Contains(ffErr.Stderr, "args:") || !strings.HasSuffix(ffErr.Stderr, "Invalid data found when processing input\n") {
+		t.Errorf("Stderr = %q, want the last 256 bytes", ffErr.Stderr)
+	}
+	if ffErr.Progress.Frame != 90 {
+		t.Errorf("Progress = %+v, want the last report", ffErr.Progress)
+	}
+	if want := "ffmpeg: exit status 1: in.mp4: Invalid data found when processing input"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := Run(context.Background(), nil, &Options{Binary: fakeBinary(t, "hang"), Timeout: 500 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s, want it killed after the timeout", elapsed)
+	}
+	var ffErr *Error
+	if !errors.As(err, &ffErr) {
+		t.Fatalf("Run error = %v, want an *Error", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || ffErr.Timeout != 500*time.Millisecond || ffErr.ExitCode != -1 {
+		t.Errorf("Error = %+v, want a 500ms timeout", ffErr)
+	}
+	if LastLine(ffErr.Stderr) != "still encoding" {
+		t.Errorf("Stderr = %q, want the output before the kill", Tail(ffErr.Stderr, 100))
+	}
+	if want := "ffmpeg: timed out after 500ms at 3s: still encoding"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err, want)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	// A caller giving up is not a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := Run(ctx, nil, &Options{Binary: fakeBinary(t, "hang"), Timeout: time.Minute})
+	var ffErr *Error
+	if !errors.As(err, &ffErr) {
+		t.Fatalf("Run error = %v, want an *Error", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || ffErr.Timeout != 0 {
+		t.Errorf("Error = %+v, want the context's error and no timeout", ffErr)
+	}
+}
+
+func TestLastLine(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"", ""},
+		{"oops", "oops"},
+		{"first\nsecond\n", "second"},
+		{"first\r\nError opening input\r\n\n  ", "Error opening input"},
+		// Progress lines are separated by carriage returns
+		{"frame=1\rframe=2\r", "frame=2"},
+	}
+	for _, tt := range tests {
+		if got := LastLine(tt.output); got != tt.want {
+			t.Errorf("LastLine(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestTail(t *testing.T) {
+	tests := []struct {
+		output string
+		n      int
+		want   string
+	}{
+		{"short\n", 10, "short\n"},
+		{"first line\nsecond\n", 10, "second\n"},
+		{"abcdef", 3, "def"},
+		// A cut that leaves only the line ending keeps the partial line
+		{"abcdef\n", 3, "ef\n"},
+		{"ééé", 5, "éé"},
+	}
+	for _, tt := range tests {
+		if got := Tail(tt.output, tt.n); got != tt.want {
+			t.Errorf("Tail(%q, %d) = %q, want %q", tt.output, tt.n, got, tt.want)
+		}
+	}
+}
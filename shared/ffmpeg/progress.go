@@ -0,0 +1,101 @@
+package ffmpeg
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is one of the reports ffmpeg writes with -progress. Fields ffmpeg
+// reports as N/A, like the speed before the first frame, are zero.
+type Progress struct {
+	Frame int64
+	FPS   float64
+	// OutTime is how far into the output ffmpeg has got.
+	OutTime time.Duration
+	// Speed is the encoding speed as a multiple of real time.
+	Speed float64
+	// TotalSize is the output size so far in bytes.
+	TotalSize int64
+	// Done is set on the final report.
+	Done bool
+}
+
+// LogValue logs the report as a group, with OutTime in seconds.
+func (p Progress) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Int64("frame", p.Frame),
+		slog.Float64("fps", p.FPS),
+		slog.Float64("outTime", p.OutTime.Seconds()),
+		slog.Float64("speed", p.Speed),
+		slog.Int64("totalSize", p.TotalSize),
+		slog.Bool("done", p.Done))
+}
+
+// progressWriter parses the key=value lines ffmpeg writes for -progress. Each
+// report ends with a progress=continue or progress=end line.
+type progressWriter struct {
+	report   func(Progress)
+	interval time.Duration
+
+	partial  []byte
+	current  Progress
+	last     Progress
+	reported time.Time
+	// pending is set when a report was skipped by the interval.
+	pending bool
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(string(bytes.TrimSpace(w.partial[:i])))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *progressWriter) line(line string) {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch key {
+	case "frame":
+		w.current.Frame, _ = strconv.ParseInt(value, 10, 64)
+	case "fps":
+		w.current.FPS, _ = strconv.ParseFloat(value, 64)
+	case "out_time_us":
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			w.current.OutTime = time.Duration(us) * time.Microsecond
+		}
+	case "speed":
+		w.current.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+	case "total_size":
+		w.current.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+	case "progress":
+		w.current.Done = value == "end"
+		w.last = w.current
+		w.pending = true
+		if w.current.Done || time.Since(w.reported) >= w.interval {
+			w.flush()
+		}
+	}
+}
+
+// flush reports the last progress if it hasn't been reported yet.
+func (w *progressWriter) flush() {
+	if !w.pending || w.report == nil {
+		return
+	}
+	w.pending = false
+	w.reported = time.Now()
+	w.report(w.last)
+}
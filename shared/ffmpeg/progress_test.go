@@ -0,0 +1,88 @@
+package ffmpeg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressWriter(t *testing.T) {
+	const running = "frame=120\nfps=29.97\nout_time_us=4000000\nspeed=1.5x\ntotal_size=262144\nprogress=continue\n"
+	tests := []struct {
+		name     string
+		writes   []string
+		interval time.Duration
+		want     []Progress
+	}{
+		{
+			"report",
+			[]string{running},
+			0,
+			[]Progress{{Frame: 120, FPS: 29.97, OutTime: 4 * time.Second, Speed: 1.5, TotalSize: 262144}},
+		},
+		// Before the first frame ffmpeg has nothing to report for most fields
+		{
+			"not available",
+			[]string{"frame=0\nfps=0.00\nout_time_us=N/A\nspeed=N/A\ntotal_size=N/A\nprogress=continue\n"},
+			0,
+			[]Progress{{}},
+		},
+		{
+			"end",
+			[]string{running, "frame=150\nout_time_us=5000000\nprogress=end\n"},
+			0,
+			[]Progress{
+				{Frame: 120, FPS: 29.97, OutTime: 4 * time.Second, Speed: 1.5, TotalSize: 262144},
+				{Frame: 150, FPS: 29.97, OutTime: 5 * time.Second, Speed: 1.5, TotalSize: 262144, Done: true},
+			},
+		},
+		// A report is only made once its progress line has arrived
+		{
+			"partial writes",
+			[]string{"frame=1", "20\nfps=29.97\nout_t", "ime_us=4000000\n", "progress=con", "tinue\nframe=121\n"},
+			0,
+			[]Progress{{Frame: 120, FPS: 29.97, OutTime: 4 * time.Second}},
+		},
+		// Reports within the interval are skipped, but never the final one
+		{
+			"interval",
+			[]string{running, "frame=130\nprogress=continue\n", "frame=150\nprogress=end\n"},
+			time.Hour,
+			[]Progress{
+				{Frame: 120, FPS: 29.97, OutTime: 4 * time.Second, Speed: 1.5, TotalSize: 262144},
+				{Frame: 150, FPS: 29.97, OutTime: 4 * time.Second, Speed: 1.5, TotalSize: 262144, Done: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Progress
+			w := &progressWriter{report: func(p Progress) { got = append(got, p) }, interval: tt.interval}
+			for _, s := range tt.writes {
+				if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", s, n, err)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("reports = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("report %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestProgressWriterFlush(t *testing.T) {
+	var got []Progress
+	w := &progressWriter{report: func(p Progress) { got = append(got, p) }, interval: time.Hour}
+	w.Write([]byte("frame=1\nprogress=continue\nframe=2\nprogress=continue\n"))
+
+	// The run ended without progress=end, so the skipped report is flushed
+	w.flush()
+	w.flush()
+	if len(got) != 2 || got[1].Frame != 2 {
+		t.Errorf("reports = %+v, want frames 1 and 2", got)
+	}
+}
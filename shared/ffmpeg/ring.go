@@ -0,0 +1,48 @@
+package ffmpeg
+
+import "strings"
+
+// ring keeps the last len(buf) bytes written to it. ffmpeg can log a line per
+// frame, so stderr is bounded rather than buffered whole.
+type ring struct {
+	buf  []byte
+	next int
+	full bool
+}
+
+func newRing(size int) *ring {
+	return &ring{buf: make([]byte, size)}
+}
+
+func (r *ring) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= len(r.buf) {
+		copy(r.buf, p[n-len(r.buf):])
+		r.next = 0
+		r.full = true
+		return n, nil
+	}
+	copied := copy(r.buf[r.next:], p)
+	if copied < n {
+		copy(r.buf, p[copied:])
+		r.full = true
+	}
+	r.next = (r.next + n) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+	return n, nil
+}
+
+// String returns the kept bytes. Once older output has been dropped it starts at
+// the next full line.
+func (r *ring) String() string {
+	if !r.full {
+		return string(r.buf[:r.next])
+	}
+	s := string(r.buf[r.next:]) + string(r.buf[:r.next])
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[i+1:]
+	}
+	return strings.ToValidUTF8(s, "")
+}
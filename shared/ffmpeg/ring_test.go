@@ -0,0 +1,34 @@
+package ffmpeg
+
+import "testing"
+
+func TestRing(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"not full", 8, []string{"ab", "c"}, "abc"},
+		{"exactly full", 8, []string{"abcd", "efgh"}, "abcdefgh"},
+		// Once output is dropped the partial first line goes with it
+		{"wraps around", 8, []string{"one\n", "two\n", "six\n"}, "six\n"},
+		{"write split across the end", 8, []string{"abcdef", "ghij\nk"}, "k"},
+		{"write larger than the buffer", 8, []string{"0123456789\nab"}, "ab"},
+		// A multi-byte character cut in half at the start is dropped
+		{"cut character", 5, []string{"ééé"}, "éé"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRing(tt.size)
+			for _, w := range tt.writes {
+				if n, err := r.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultProgressInterval is how often a Runner logs progress when ProgressInterval is zero.
+const DefaultProgressInterval = 30 * time.Second
+
+// Runner runs ffmpeg as a named step of a service: in a span, with its progress
+// logged, and with its runtime recorded by step and outcome. Tracer and Duration are
+// the service's own, so the spans and histogram keep the service's names.
+type Runner struct {
+	Tracer trace.Tracer
+	// Duration records each step's seconds, with the step under StepKey and "ok" or
+	// "error" under "outcome".
+	Duration metric.Float64Histogram
+	// StepKey is the attribute and log key the step is recorded under. Empty uses "step".
+	StepKey string
+	// Logger logs progress and failures. Nil uses slog.Default().
+	Logger *slog.Logger
+	// Timeout kills a run that takes longer. Zero leaves it to ctx.
+	Timeout time.Duration
+	// ProgressInterval is how often a running step logs its progress.
+	ProgressInterval time.Duration
+}
+
+// Run runs ffmpeg with args as step, logging its progress and, when it fails, the
+// end of its output. A failure is returned as an *Error.
+func (r *Runner) Run(ctx context.Context, step string, args []string) (result *Result, err error) {
+	logger := r.logger()
+	interval := r.ProgressInterval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	err = r.Time(ctx, step, func(ctx context.Context) error {
+		result, err = Run(ctx, args, &Options{
+			Timeout:          r.Timeout,
+			ProgressInterval: interval,
+			OnProgress: func(p Progress) {
+				logger.InfoContext(ctx, "FFmpeg progress", r.stepKey(), step, "progress", p)
+			},
+		})
+		return err
+	})
+	var ffErr *Error
+	if errors.As(err, &ffErr) {
+		logger.WarnContext(ctx, "FFmpeg failed", r.stepKey(), step, "exitCode", ffErr.ExitCode, "stderr", ffErr.Stderr)
+	}
+	return result, err
+}
+
+// Time runs f, which runs ffmpeg some other way, in a span for step and records its
+// duration like Run does.
+func (r *Runner) Time(ctx context.Context, step string, f func(context.Context) error) error {
+	ctx, span := telemetry.Start(ctx, r.Tracer, "ffmpeg "+step)
+	start := time.Now()
+	err := f(ctx)
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	r.Duration.Record(ctx, telemetry.Seconds(start), metric.WithAttributes(
+		attribute.String(r.stepKey(), step),
+		attribute.String("outcome", outcome)))
+	telemetry.End(span, err)
+	return err
+}
+
+func (r *Runner) stepKey() string {
+	if r.StepKey == "" {
+		return "step"
+	}
+	return r.StepKey
+}
+
+func (r *Runner) logger() *slog.Logger {
+	if r.Logger == nil {
+		return slog.Default()
+	}
+	return r.Logger
+}
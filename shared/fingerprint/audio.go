@@ -0,0 +1,123 @@
+package fingerprint
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+)
+
+const (
+	audioSampleRate = 8000
+	// audioWindow samples are analysed for each sub-fingerprint, audioHop apart.
+	audioWindow = 2048
+	audioHop    = 500
+	// AudioRate is how many sub-fingerprints there are per second.
+	AudioRate = audioSampleRate / audioHop
+	// audioPerFrame is how many sub-fingerprints cover one hashed frame.
+	audioPerFrame = AudioRate / FrameRate
+
+	// audioBands log-spaced bands between audioMinFreq and audioMaxFreq give 32 bits.
+	audioBands   = 33
+	audioMinFreq = 300.0
+	audioMaxFreq = 2000.0
+
+	// silenceRMS is about -60 dBFS. Quieter clips get no audio fingerprint, since
+	// every silent clip would match every other.
+	silenceRMS = 32.0
+)
+
+// audioHashes decodes the sound as 8 kHz mono and returns its sub-fingerprints,
+// or nil if it is silent. Each bit records whether the energy difference between
+// two adjacent bands grew or shrank since the previous window.
+func audioHashes(ctx context.Context, path string) ([]uint32, error) {
+	cmd := deadline.Command(ctx, "ffmpeg", "-v", "error", "-i", path, "-vn",
+		"-ac", "1", "-ar", fmt.Sprint(audioSampleRate), "-f", "s16le", "-")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cmd.Output: %v", err)
+	}
+
+	samples := make([]float64, len(output)/2)
+	sumSquares := 0.0
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(output[2*i:])))
+		sumSquares += samples[i] * samples[i]
+	}
+	if len(samples) < audioWindow || math.Sqrt(sumSquares/float64(len(samples))) < silenceRMS {
+		return nil, nil
+	}
+
+	edges := bandEdges()
+	window := make([]complex128, audioWindow)
+	var hashes []uint32
+	var previous []float64
+	for start := 0; start+audioWindow <= len(samples); start += audioHop {
+		for i := range window {
+			// Hann window
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(audioWindow-1))
+			window[i] = complex(samples[start+i]*w, 0)
+		}
+		fft(window)
+
+		energies := make([]float64, audioBands)
+		for b := range energies {
+			for bin := edges[b]; bin < edges[b+1]; bin++ {
+				magnitude := cmplx.Abs(window[bin])
+				energies[b] += magnitude * magnitude
+			}
+		}
+
+		if previous != nil {
+			var hash uint32
+			for b := 0; b < audioBands-1; b++ {
+				if (energies[b]-energies[b+1])-(previous[b]-previous[b+1]) > 0 {
+					hash |= 1 << uint(b)
+				}
+			}
+			hashes = append(hashes, hash)
+		}
+		previous = energies
+	}
+	return hashes, nil
+}
+
+// bandEdges returns the FFT bin each band starts at, plus the end of the last band.
+func bandEdges() []int {
+	edges := make([]int, audioBands+1)
+	for i := range edges {
+		freq := audioMinFreq * math.Pow(audioMaxFreq/audioMinFreq, float64(i)/audioBands)
+		edges[i] = int(math.Round(freq * audioWindow / audioSampleRate))
+	}
+	return edges
+}
+
+// fft is an in-place iterative radix-2 Cooley-Tukey transform. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := x[start+k], w*x[start+k+size/2]
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+}
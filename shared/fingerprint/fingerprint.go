@@ -0,0 +1,125 @@
+// Package fingerprint computes perceptual fingerprints of videos so the same clip
+// can be recognised after it has been re-encoded, resized or reposted elsewhere.
+//
+// The picture is sampled at FrameRate frames per second and each frame is reduced
+// to a 64 bit average hash. The sound is reduced to 32 bit sub-fingerprints of
+// band energy changes, AudioRate per second, in the style of Haitsma and Kalker's
+// audio fingerprinting. Both are computed from ffmpeg output.
+package fingerprint
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Version changes whenever fingerprints computed by an older version can't be compared.
+const Version = 1
+
+// Fingerprint is the perceptual summary of one clip.
+type Fingerprint struct {
+	Version int `json:"version"`
+	// Frames are the average hashes of frames sampled at FrameRate per second.
+	Frames []uint64 `json:"frames"`
+	// Audio are the sub-fingerprints of the sound at AudioRate per second.
+	// Empty when the clip is silent.
+	Audio []uint32 `json:"audio,omitempty"`
+}
+
+// Compute fingerprints the video at path.
+func Compute(ctx context.Context, path string) (*Fingerprint, error) {
+	frames, err := frameHashes(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("frame hashes: %v", err)
+	}
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames decoded from %s", path)
+	}
+	audio, err := audioHashes(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("audio hashes: %v", err)
+	}
+	return &Fingerprint{Version: Version, Frames: frames, Audio: audio}, nil
+}
+
+// Comparison is how alike two fingerprints are at their best alignment.
+// Similarities run from about 0.5 for unrelated clips to 1 for identical ones.
+type Comparison struct {
+	Video float64
+	// Audio is only meaningful when HasAudio is set, which needs sound in both clips.
+	Audio    float64
+	HasAudio bool
+	// Offset is how many seconds later the second clip starts in the first.
+	Offset float64
+}
+
+// Duplicate reports whether the picture reaches threshold and, when both clips have
+// sound, the sound reaches audioThreshold. Requiring both keeps memes that reuse a
+// popular sound over different footage apart.
+func (c Comparison) Duplicate(threshold, audioThreshold float64) bool {
+	if c.Video < threshold {
+		return false
+	}
+	return !c.HasAudio || c.Audio >= audioThreshold
+}
+
+// Compare aligns b against a and measures their similarity. The alignment comes from
+// the frames, so a clip trimmed or padded at either end still matches, as long as the
+// clips overlap for at least half of the shorter one.
+func Compare(a, b *Fingerprint) Comparison {
+	var c Comparison
+	if a.Version != b.Version {
+		return c
+	}
+
+	video, offset, ok := align(len(a.Frames), len(b.Frames), 0, len(a.Frames)+len(b.Frames), func(i, j int) int {
+		return bits.OnesCount64(a.Frames[i] ^ b.Frames[j])
+	}, 64)
+	if !ok {
+		return c
+	}
+	c.Video = video
+	c.Offset = float64(offset) / FrameRate
+
+	if len(a.Audio) > 0 && len(b.Audio) > 0 {
+		// Only refine the audio alignment within a second of the picture's
+		audio, _, ok := align(len(a.Audio), len(b.Audio), offset*audioPerFrame, AudioRate, func(i, j int) int {
+			return bits.OnesCount32(a.Audio[i] ^ b.Audio[j])
+		}, 32)
+		c.Audio, c.HasAudio = audio, ok
+	}
+	return c
+}
+
+// align slides sequence b over sequence a, with b's first element at offsets within
+// radius of center, and returns the best similarity, that offset, and whether any
+// offset had enough overlap. distance returns the differing bits of a[i] and b[j].
+func align(n, m, center, radius int, distance func(i, j int) int, width int) (float64, int, bool) {
+	minOverlap := (min(n, m) + 1) / 2
+	if minOverlap == 0 {
+		return 0, 0, false
+	}
+
+	best, bestOffset, found := 0.0, 0, false
+	for offset := max(center-radius, minOverlap-m); offset <= min(center+radius, n-minOverlap); offset++ {
+		start, end := max(0, offset), min(n, offset+m)
+		differing := 0
+		for i := start; i < end; i++ {
+			differing += distance(i, i-offset)
+		}
+		similarity := 1 - float64(differing)/float64((end-start)*width)
+		// Prefer the smallest shift on ties so identical clips align at zero
+		if !found || similarity > best || (similarity == best && abs(offset-center) < abs(bestOffset-center)) {
+			best, bestOffset, found = similarity, offset, true
+		}
+	}
+	return math.Round(best*1000) / 1000, bestOffset, found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
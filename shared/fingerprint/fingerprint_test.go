@@ -0,0 +1,134 @@
+package fingerprint
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestFFT(t *testing.T) {
+	// cosine is one cycle of a cosine over eight samples
+	cosine := make([]complex128, 8)
+	for i := range cosine {
+		cosine[i] = complex(math.Cos(2*math.Pi*float64(i)/8), 0)
+	}
+
+	tests := []struct {
+		name string
+		in   []complex128
+		want []complex128
+	}{
+		{"single sample", []complex128{3}, []complex128{3}},
+		{"impulse", []complex128{1, 0, 0, 0}, []complex128{1, 1, 1, 1}},
+		{"constant", []complex128{1, 1, 1, 1}, []complex128{4, 0, 0, 0}},
+		{"alternating", []complex128{1, -1, 1, -1}, []complex128{0, 0, 4, 0}},
+		{"shifted impulse", []complex128{0, 1, 0, 0}, []complex128{1, -1i, -1, 1i}},
+		{"cosine", cosine, []complex128{0, 4, 0, 0, 0, 0, 0, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x := append([]complex128(nil), tt.in...)
+			fft(x)
+			for i := range x {
+				if cmplx.Abs(x[i]-tt.want[i]) > 1e-9 {
+					t.Fatalf("fft(%v) = %v, want %v", tt.in, x, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// randomFingerprint returns a fingerprint of seconds of unrelated frames and sound.
+func randomFingerprint(r *rand.Rand, seconds int) *Fingerprint {
+	fp := &Fingerprint{Version: Version, Frames: make([]uint64, seconds*FrameRate), Audio: make([]uint32, seconds*AudioRate)}
+	for i := range fp.Frames {
+		fp.Frames[i] = r.Uint64()
+	}
+	for i := range fp.Audio {
+		fp.Audio[i] = r.Uint32()
+	}
+	return fp
+}
+
+// cut returns the part of fp from second start to second end.
+func cut(fp *Fingerprint, start, end int) *Fingerprint {
+	return &Fingerprint{
+		Version: fp.Version,
+		Frames:  fp.Frames[start*FrameRate : end*FrameRate],
+		Audio:   fp.Audio[start*AudioRate : end*AudioRate],
+	}
+}
+
+func TestCompare(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	clip := randomFingerprint(r, 10)
+	other := randomFingerprint(r, 10)
+
+	// overlapping shares its first two seconds with the end of clip and is
+	// unrelated after that, less than half of either
+	overlapping := randomFingerprint(r, 10)
+	copy(overlapping.Frames, clip.Frames[8*FrameRate:])
+	copy(overlapping.Audio, clip.Audio[8*AudioRate:])
+
+	newer := *clip
+	newer.Version = Version + 1
+	silent := *clip
+	silent.Audio = nil
+
+	// A nil want only requires the clips not to be duplicates, since unrelated
+	// clips have no exact similarity
+	tests := []struct {
+		name string
+		b    *Fingerprint
+		want *Comparison
+	}{
+		{"identical", clip, &Comparison{Video: 1, Audio: 1, HasAudio: true}},
+		// Reposted without its first two seconds
+		{"offset", cut(clip, 2, 10), &Comparison{Video: 1, Audio: 1, HasAudio: true, Offset: 2}},
+		{"trimmed at both ends", cut(clip, 1, 8), &Comparison{Video: 1, Audio: 1, HasAudio: true, Offset: 1}},
+		{"silent", &silent, &Comparison{Video: 1}},
+		{"unrelated", other, nil},
+		{"overlap too short", overlapping, nil},
+		{"version mismatch", &newer, &Comparison{}},
+		{"no frames", &Fingerprint{Version: Version}, &Comparison{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Compare(clip, tt.b)
+			if tt.want == nil {
+				if got.Duplicate(0.9, 0.65) {
+					t.Errorf("Compare = %+v, want no duplicate", got)
+				}
+				return
+			}
+			if got != *tt.want {
+				t.Errorf("Compare = %+v, want %+v", got, *tt.want)
+			}
+		})
+	}
+}
+
+func TestComparisonDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Comparison
+		want bool
+	}{
+		{"both over", Comparison{Video: 0.95, Audio: 0.8, HasAudio: true}, true},
+		{"at the thresholds", Comparison{Video: 0.9, Audio: 0.65, HasAudio: true}, true},
+		{"picture under", Comparison{Video: 0.89, Audio: 1, HasAudio: true}, false},
+		// The same sound over different footage
+		{"sound only", Comparison{Video: 0.6, Audio: 1, HasAudio: true}, false},
+		// The same footage with a different sound
+		{"picture only", Comparison{Video: 1, Audio: 0.5, HasAudio: true}, false},
+		{"no sound to compare", Comparison{Video: 0.95}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.Duplicate(0.9, 0.65); got != tt.want {
+				t.Errorf("Duplicate(0.9, 0.65) = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
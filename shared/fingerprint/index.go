@@ -0,0 +1,88 @@
+package fingerprint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+)
+
+// indexSuffix is appended to the clip's object name to name its fingerprint.
+const indexSuffix = ".fingerprint.json"
+
+// Index stores one fingerprint object per clip in a bucket. Fingerprints outlive the
+// clips, so a meme that was in last week's compilation is still caught this week.
+type Index struct {
+	Store  blobstore.Store
+	Bucket string
+}
+
+// Match is the indexed clip a fingerprint was found to duplicate.
+type Match struct {
+	// Name is the clip's object name.
+	Name string
+	Comparison
+}
+
+// Add stores the fingerprint of the clip called name.
+func (ix *Index) Add(ctx context.Context, name string, fp *Fingerprint) error {
+	data, err := json.Marshal(fp)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	opts := &blobstore.PutOptions{ContentType: "application/json"}
+	if _, err := ix.Store.Put(ctx, ix.Bucket, name+indexSuffix, bytes.NewReader(data), opts); err != nil {
+		return fmt.Errorf("Store.Put: %v", err)
+	}
+	return nil
+}
+
+// FindDuplicate compares fp with every fingerprint indexed since the given time (all of
+// them if it is zero) and returns the most similar duplicate, or nil if there is none.
+// name is skipped so a clip that is normalized again doesn't match itself.
+func (ix *Index) FindDuplicate(ctx context.Context, name string, fp *Fingerprint, since time.Time, threshold, audioThreshold float64) (*Match, error) {
+	objects, err := blobstore.ListAll(ctx, ix.Store, ix.Bucket, "")
+	if err != nil {
+		return nil, fmt.Errorf("blobstore.ListAll: %v", err)
+	}
+
+	var best *Match
+	for _, object := range objects {
+		clip, ok := strings.CutSuffix(object.Name, indexSuffix)
+		if !ok || clip == name || object.Created.Before(since) {
+			continue
+		}
+
+		indexed, err := ix.get(ctx, object.Name)
+		if err != nil {
+			// One unreadable entry shouldn't stop every later clip from being checked
+			slog.WarnContext(ctx, "Skipping unreadable fingerprint", "object", object.Name, "error", err)
+			continue
+		}
+
+		comparison := Compare(fp, indexed)
+		if comparison.Duplicate(threshold, audioThreshold) && (best == nil || comparison.Video > best.Video) {
+			best = &Match{Name: clip, Comparison: comparison}
+		}
+	}
+	return best, nil
+}
+
+func (ix *Index) get(ctx context.Context, name string) (*Fingerprint, error) {
+	reader, err := ix.Store.Get(ctx, ix.Bucket, name)
+	if err != nil {
+		return nil, fmt.Errorf("Store.Get: %v", err)
+	}
+	defer reader.Close()
+
+	var fp Fingerprint
+	if err := json.NewDecoder(reader).Decode(&fp); err != nil {
+		return nil, fmt.Errorf("json.Decode: %v", err)
+	}
+	return &fp, nil
+}
@@ -0,0 +1,67 @@
+package fingerprint
+
+import (
+	"context"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+)
+
+func TestIndexFindDuplicate(t *testing.T) {
+	ctx := context.Background()
+	r := rand.New(rand.NewPCG(3, 4))
+	clip := randomFingerprint(r, 10)
+	repost := cut(clip, 1, 10)
+
+	ix := &Index{Store: testutil.Store(t), Bucket: "fingerprints"}
+	add := func(name string, fp *Fingerprint) time.Time {
+		t.Helper()
+		if err := ix.Add(ctx, name, fp); err != nil {
+			t.Fatalf("Add(%s): %v", name, err)
+		}
+		attrs, err := ix.Store.Stat(ctx, ix.Bucket, name+indexSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attrs.Created
+	}
+	lastWeek := add("last-week.mp4", repost)
+	add("unrelated.mp4", randomFingerprint(r, 10))
+	add("clip.mp4", clip)
+	// An unreadable entry is skipped rather than failing the lookup
+	if _, err := ix.Store.Put(ctx, ix.Bucket, "broken.mp4"+indexSuffix, strings.NewReader("{"), &blobstore.PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		clip  string
+		since time.Time
+		want  string
+	}{
+		// The clip's own fingerprint is identical, but it's the clip itself
+		{"skips itself", "clip.mp4", time.Time{}, "last-week.mp4"},
+		{"best match", "new.mp4", time.Time{}, "clip.mp4"},
+		{"inside the window", "clip.mp4", lastWeek, "last-week.mp4"},
+		{"outside the window", "clip.mp4", lastWeek.Add(time.Nanosecond), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := ix.FindDuplicate(ctx, tt.clip, clip, tt.since, 0.9, 0.65)
+			if err != nil {
+				t.Fatalf("FindDuplicate: %v", err)
+			}
+			got := ""
+			if match != nil {
+				got = match.Name
+			}
+			if got != tt.want {
+				t.Errorf("FindDuplicate = %+v, want %q", match, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package fingerprint
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+)
+
+// FrameRate is how many frames per second are hashed.
+const FrameRate = 4
+
+// hashSide is the width and height frames are reduced to, one bit per pixel.
+const hashSide = 8
+
+// frameHashes decodes the video as tiny grayscale frames and returns their average hashes.
+func frameHashes(ctx context.Context, path string) ([]uint64, error) {
+	cmd := deadline.Command(ctx, "ffmpeg", "-v", "error", "-i", path, "-an",
+		"-vf", fmt.Sprintf("fps=%d,scale=%d:%d:flags=area,format=gray", FrameRate, hashSide, hashSide),
+		"-f", "rawvideo", "-")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("cmd.Output: %v", err)
+	}
+
+	const frameSize = hashSide * hashSide
+	hashes := make([]uint64, 0, len(output)/frameSize)
+	for len(output) >= frameSize {
+		hashes = append(hashes, averageHash(output[:frameSize]))
+		output = output[frameSize:]
+	}
+	return hashes, nil
+}
+
+// averageHash sets a bit for every pixel brighter than the frame's mean.
+func averageHash(pixels []byte) uint64 {
+	total := 0
+	for _, p := range pixels {
+		total += int(p)
+	}
+	// Compare against the sum instead of the mean to avoid rounding
+	var hash uint64
+	for i, p := range pixels {
+		if int(p)*len(pixels) > total {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Header carries the correlation ID on HTTP requests between the services.
+const Header = "X-Correlation-ID"
+
+type contextKey struct{}
+
+// validID bounds what is accepted from a request header or object metadata.
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// NewID returns a random correlation ID. It is 32 hex characters, the format
+// Cloud Trace uses for trace IDs.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithID returns a context carrying the correlation ID. Invalid IDs are ignored.
+func WithID(ctx context.Context, id string) context.Context {
+	if !validID.MatchString(id) {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// ID returns the correlation ID carried by the context, or empty if there is none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromRequest returns the correlation ID sent with the request: the Header, or
+// the trace ID of a Cloud Trace or W3C traceparent header. It returns empty if
+// none is set or valid.
+func FromRequest(r *http.Request) string {
+	if id := r.Header.Get(Header); validID.MatchString(id) {
+		return id
+	}
+	// TRACE_ID/SPAN_ID;o=OPTIONS
+	if trace, _, _ := strings.Cut(r.Header.Get("X-Cloud-Trace-Context"), "/"); validID.MatchString(trace) {
+		return trace
+	}
+	// VERSION-TRACE_ID-PARENT_ID-FLAGS
+	if parts := strings.Split(r.Header.Get("traceparent"), "-"); len(parts) == 4 && validID.MatchString(parts[1]) {
+		return parts[1]
+	}
+	return ""
+}
+
+// Middleware puts the request's correlation ID, or a new one, in the request
+// context and echoes it in the response Header.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromRequest(r)
+		if id == "" {
+			id = NewID()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(WithID(r.Context(), id)))
+	})
+}
+
+// Transport sets the Header on outgoing requests whose context carries a correlation ID.
+type Transport struct {
+	// Base is the underlying transport, http.DefaultTransport if nil.
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if id := ID(r.Context()); id != "" && r.Header.Get(Header) == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set(Header, id)
+	}
+	return base.RoundTrip(r)
+}
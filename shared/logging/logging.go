@@ -0,0 +1,117 @@
+// Package logging configures log/slog to write JSON that Cloud Logging parses
+// into structured entries, and carries a correlation ID that follows a meme
+// from the Discord interaction through download, normalize and concatenate.
+//
+// Every entry logged with a context holding an ID gets a correlationId field
+// and, when the project is known, the logging.googleapis.com/trace field, so
+// Cloud Logging groups the entries from every service under one trace.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Cloud Logging special fields. See https://cloud.google.com/logging/docs/structured-logging.
+const (
+	keySeverity       = "severity"
+	keyMessage        = "message"
+	keySourceLocation = "logging.googleapis.com/sourceLocation"
+	keyTrace          = "logging.googleapis.com/trace"
+	// KeyCorrelationID is the field holding the correlation ID on every entry.
+	KeyCorrelationID = "correlationId"
+)
+
+// Setup makes a Cloud Logging JSON handler the default for slog and routes the
+// standard log package through it. The trace field needs the project ID, read
+// from GOOGLE_CLOUD_PROJECT; without it only the correlationId field is set.
+// LOG_FORMAT=text switches to slog's text format for reading logs in a terminal.
+func Setup(service string) {
+	options := &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level(),
+		ReplaceAttr: replaceAttr,
+	}
+	var handler slog.Handler = slog.NewJSONHandler(os.Stderr, options)
+	if os.Getenv("LOG_FORMAT") == "text" {
+		handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: options.Level})
+	}
+	logger := slog.New(&traceHandler{
+		Handler: handler,
+		project: os.Getenv("GOOGLE_CLOUD_PROJECT"),
+	}).With("serviceContext", map[string]string{"service": service})
+	// This also sends the log package, and the libraries using it, through the handler
+	slog.SetDefault(logger)
+}
+
+// Fatal logs at error level and exits, for startup failures.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// level reads LOG_LEVEL (debug, info, warn or error), defaulting to info.
+func level() slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+// replaceAttr renames slog's built-in fields to the ones Cloud Logging reads.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		a.Key = keySeverity
+		a.Value = slog.StringValue(severity(a.Value.Any().(slog.Level)))
+	case slog.MessageKey:
+		a.Key = keyMessage
+	case slog.SourceKey:
+		a.Key = keySourceLocation
+	}
+	return a
+}
+
+// severity maps a slog level to a Cloud Logging LogSeverity.
+func severity(l slog.Level) string {
+	switch {
+	case l >= slog.LevelError:
+		return "ERROR"
+	case l >= slog.LevelWarn:
+		return "WARNING"
+	case l >= slog.LevelInfo:
+		return "INFO"
+	default:
+		return "DEBUG"
+	}
+}
+
+// traceHandler adds the correlation ID from the context to every record.
+type traceHandler struct {
+	slog.Handler
+	project string
+}
+
+func (h *traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := ID(ctx); id != "" {
+		r.AddAttrs(slog.String(KeyCorrelationID, id))
+		if h.project != "" {
+			r.AddAttrs(slog.String(keyTrace, fmt.Sprintf("projects/%s/traces/%s", h.project, id)))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &traceHandler{Handler: h.Handler.WithAttrs(attrs), project: h.project}
+}
+
+func (h *traceHandler) WithGroup(name string) slog.Handler {
+	return &traceHandler{Handler: h.Handler.WithGroup(name), project: h.project}
+}
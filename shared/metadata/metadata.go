@@ -0,0 +1,136 @@
+// Package metadata defines the object metadata keys the video services use to
+// carry information about a clip from download through to concatenate.
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Object metadata keys. Cloud Storage lowercases keys, so keep them lowercase.
+const (
+	KeySourceURL = "source-url"
+	KeyUploader  = "uploader"
+	KeySubmitter = "submitter"
+	KeyExtractor = "extractor"
+	// KeyDuration is the normalized clip's length in seconds, written by normalize.
+	KeyDuration = "duration"
+	// KeyDuplicateOf names the clip a near-duplicate matched. Normalize sets it when
+	// dedupe flags a clip, and concatenate leaves flagged clips out.
+	KeyDuplicateOf = "duplicate-of"
+	// KeySimilarity is the picture similarity to the KeyDuplicateOf clip.
+	KeySimilarity = "similarity"
+	// KeySourceGeneration is the generation of the quarantine object a normalized clip
+	// was made from. Normalize uses it to recognise redelivered events.
+	KeySourceGeneration = "source-generation"
+	// KeyNormalizedAt is when normalize published the clip, in RFC 3339.
+	KeyNormalizedAt = "normalized-at"
+	// KeyCorrelationID is the logging correlation ID of the submission that
+	// brought the clip in, so normalize and concatenate log under the same ID.
+	KeyCorrelationID = "correlation-id"
+	// KeyProfile is the normalization profile. Set on a video in quarantine it picks
+	// the profile the video is normalized to, and normalize records the profile it
+	// used on the clip so concatenate only joins clips of the same one.
+	KeyProfile = "profile"
+	// KeyFill picks how normalize fills the rest of the frame, like KeyProfile.
+	KeyFill = "fill"
+	// KeyTrimStart and KeyTrimEnd are the part of the source the submitter picked, in
+	// seconds. Normalize cuts the clip to them and records the part it used.
+	KeyTrimStart = "trim-start"
+	KeyTrimEnd   = "trim-end"
+)
+
+// Keys normalize sets on a video it failed to normalize. The attempt count and last
+// error are kept on the source while it is retried, and the rest are added when it
+// is moved to the failed bucket.
+const (
+	KeyNormalizeAttempts = "normalize-attempts"
+	// KeyNormalizeError is the last attempt's error.
+	KeyNormalizeError = "normalize-error"
+	// KeyFFmpegStderr is the end of ffmpeg's output when ffmpeg was what failed.
+	KeyFFmpegStderr = "ffmpeg-stderr"
+	// KeyProbeSummary is ffprobe's summary of the source's streams.
+	KeyProbeSummary = "probe-summary"
+	// KeySourceBucket is the bucket a failed video is requeued to.
+	KeySourceBucket = "source-bucket"
+	// KeyFailedAt is when the video was moved to the failed bucket, in RFC 3339.
+	KeyFailedAt = "failed-at"
+)
+
+// Clip is the attribution carried with every video.
+type Clip struct {
+	SourceURL string
+	Uploader  string
+	Submitter string
+	Extractor string
+}
+
+// Metadata returns the clip as object metadata, omitting empty fields.
+func (c Clip) Metadata() map[string]string {
+	m := make(map[string]string)
+	set(m, KeySourceURL, c.SourceURL)
+	set(m, KeyUploader, c.Uploader)
+	set(m, KeySubmitter, c.Submitter)
+	set(m, KeyExtractor, c.Extractor)
+	return m
+}
+
+// ClipFromMetadata reads the clip attribution from object metadata.
+func ClipFromMetadata(m map[string]string) Clip {
+	return Clip{
+		SourceURL: m[KeySourceURL],
+		Uploader:  m[KeyUploader],
+		Submitter: m[KeySubmitter],
+		Extractor: m[KeyExtractor],
+	}
+}
+
+// Credit is the text shown on the clip's lower third, or empty if there is nothing to credit.
+func (c Clip) Credit() string {
+	var lines []string
+	switch {
+	case c.Uploader != "" && c.Extractor != "":
+		lines = append(lines, fmt.Sprintf("%s on %s", c.Uploader, c.Extractor))
+	case c.Uploader != "":
+		lines = append(lines, c.Uploader)
+	case c.Extractor != "":
+		lines = append(lines, fmt.Sprintf("via %s", c.Extractor))
+	}
+	if c.Submitter != "" {
+		lines = append(lines, fmt.Sprintf("submitted by %s", c.Submitter))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Merge returns a copy of dst with every key from src added, without overwriting.
+func Merge(dst, src map[string]string) map[string]string {
+	merged := make(map[string]string, len(dst)+len(src))
+	for k, v := range src {
+		merged[k] = v
+	}
+	for k, v := range dst {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Duration returns the clip length recorded by normalize, or 0 if it is missing.
+func Duration(m map[string]string) float64 {
+	d, err := strconv.ParseFloat(m[KeyDuration], 64)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// FormatDuration formats a clip length for KeyDuration.
+func FormatDuration(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
+
+func set(m map[string]string, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
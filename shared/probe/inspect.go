@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Reason is a machine-readable rejection code.
+type Reason string
+
+const (
+	ReasonUnreadable    Reason = "unreadable"
+	ReasonNoVideoStream Reason = "no_video_stream"
+	ReasonZeroDuration  Reason = "zero_duration"
+	ReasonStillImage    Reason = "still_image"
+	ReasonNoAudioStream Reason = "no_audio_stream"
+)
+
+// Rejection explains why a file can't be used as a clip.
+type Rejection struct {
+	Reason  Reason
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("%s: %s", r.Reason, r.Message)
+}
+
+// AsRejection returns the Rejection in err's chain, if any.
+func AsRejection(err error) (*Rejection, bool) {
+	var rejection *Rejection
+	ok := errors.As(err, &rejection)
+	return rejection, ok
+}
+
+// isImageFormat reports whether ffprobe read the file with one of the demuxers for
+// single images: image2, or one of the codec_pipe demuxers like png_pipe. Formats that
+// can be animated, like gif and apng, are left to the frame count.
+func isImageFormat(formatName string) bool {
+	for _, name := range strings.Split(formatName, ",") {
+		if name == "image2" || strings.HasSuffix(name, "_pipe") {
+			return true
+		}
+	}
+	return false
+}
+
+// Inspect rejects files that can't be normalized into a clip. A missing audio
+// stream is allowed because normalize synthesizes a silent track.
+func Inspect(r *Result) error {
+	video := r.Video()
+	if video == nil {
+		return &Rejection{Reason: ReasonNoVideoStream, Message: "file has no video stream"}
+	}
+
+	if isImageFormat(r.Format.FormatName) {
+		return &Rejection{Reason: ReasonStillImage, Message: fmt.Sprintf("file is an image (%s)", r.Format.FormatName)}
+	}
+	if video.Frames() == 1 {
+		return &Rejection{Reason: ReasonStillImage, Message: "video stream has a single frame"}
+	}
+
+	if r.Duration() <= 0 {
+		return &Rejection{Reason: ReasonZeroDuration, Message: "file has no duration"}
+	}
+	return nil
+}
+
+// InspectNormalized checks that normalize produced a clip concatenate can use:
+// one video and one audio stream with a duration.
+func InspectNormalized(r *Result) error {
+	if err := Inspect(r); err != nil {
+		return err
+	}
+	if r.Audio() == nil {
+		return &Rejection{Reason: ReasonNoAudioStream, Message: "normalized file has no audio stream"}
+	}
+	return nil
+}
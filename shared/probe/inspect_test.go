@@ -0,0 +1,33 @@
+package probe
+
+import "testing"
+
+func TestInspectStillImages(t *testing.T) {
+	tests := []struct {
+		format string
+		frames string
+		want   Reason
+	}{
+		{"mov,mp4,m4a,3gp,3g2,mj2", "90", ""},
+		{"gif", "40", ""},
+		{"apng", "24", ""},
+		{"image2", "1", ReasonStillImage},
+		{"png_pipe", "", ReasonStillImage},
+		{"webp_pipe", "", ReasonStillImage},
+		{"mov,mp4,m4a,3gp,3g2,mj2", "1", ReasonStillImage},
+		{"gif", "1", ReasonStillImage},
+	}
+	for _, tt := range tests {
+		r := &Result{
+			Streams: []Stream{{CodecType: "video", NbFrames: tt.frames}},
+			Format:  Format{FormatName: tt.format, Duration: "2.000000"},
+		}
+		var got Reason
+		if rejection, ok := AsRejection(Inspect(r)); ok {
+			got = rejection.Reason
+		}
+		if got != tt.want {
+			t.Errorf("Inspect(%s with %s frames) = %q, want %q", tt.format, tt.frames, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// Package probe inspects media files with ffprobe.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/DC00/meme-compiler-cloud-functions/shared/probe")
+
+// Result is the subset of `ffprobe -show_format -show_streams` output the services use.
+type Result struct {
+	Streams []Stream `json:"streams"`
+	Format  Format   `json:"format"`
+}
+
+type Stream struct {
+	Index        int    `json:"index"`
+	CodecName    string `json:"codec_name"`
+	CodecType    string `json:"codec_type"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	AvgFrameRate string `json:"avg_frame_rate,omitempty"`
+	// NbFrames, Duration and SampleRate are strings in ffprobe's JSON and may be missing.
+	NbFrames    string      `json:"nb_frames,omitempty"`
+	Duration    string      `json:"duration,omitempty"`
+	SampleRate  string      `json:"sample_rate,omitempty"`
+	Channels    int         `json:"channels,omitempty"`
+	Disposition Disposition `json:"disposition"`
+}
+
+type Disposition struct {
+	// AttachedPic is 1 for cover art embedded as a video stream.
+	AttachedPic int `json:"attached_pic"`
+}
+
+type Format struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration,omitempty"`
+	Size       string `json:"size,omitempty"`
+	BitRate    string `json:"bit_rate,omitempty"`
+	NbStreams  int    `json:"nb_streams"`
+}
+
+// Run probes the file at path.
+func Run(ctx context.Context, path string) (result *Result, err error) {
+	ctx, span := telemetry.Start(ctx, tracer, "ffprobe")
+	defer func() { telemetry.End(span, err) }()
+
+	cmd := deadline.Command(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// ffprobe exiting non-zero means it couldn't parse the file; anything else is our problem
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, &Rejection{Reason: ReasonUnreadable, Message: fmt.Sprintf("ffprobe: %s", bytes.TrimSpace(stderr.Bytes()))}
+		}
+		return nil, fmt.Errorf("cmd.Run: %v", err)
+	}
+
+	result = new(Result)
+	if err := json.Unmarshal(stdout.Bytes(), result); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	return result, nil
+}
+
+// Video returns the first video stream that isn't embedded cover art, or nil.
+func (r *Result) Video() *Stream {
+	for i := range r.Streams {
+		s := &r.Streams[i]
+		if s.CodecType == "video" && s.Disposition.AttachedPic == 0 {
+			return s
+		}
+	}
+	return nil
+}
+
+// Audio returns the first audio stream, or nil.
+func (r *Result) Audio() *Stream {
+	for i := range r.Streams {
+		if r.Streams[i].CodecType == "audio" {
+			return &r.Streams[i]
+		}
+	}
+	return nil
+}
+
+// Duration returns the container duration in seconds, or 0 if unknown.
+func (r *Result) Duration() float64 {
+	return parseFloat(r.Format.Duration)
+}
+
+// Size returns the file size in bytes, or 0 if unknown.
+func (r *Result) Size() int64 {
+	n, _ := strconv.ParseInt(r.Format.Size, 10, 64)
+	return n
+}
+
+// Frames returns the stream's frame count, or 0 if the container doesn't record it.
+func (s *Stream) Frames() int64 {
+	n, _ := strconv.ParseInt(s.NbFrames, 10, 64)
+	return n
+}
+
+// Summary is a one-line description for logs and error reports.
+func (r *Result) Summary() string {
+	summary := fmt.Sprintf("format=%s duration=%.2fs size=%d", r.Format.FormatName, r.Duration(), r.Size())
+	if v := r.Video(); v != nil {
+		summary += fmt.Sprintf(" video=%s %dx%d@%s", v.CodecName, v.Width, v.Height, v.AvgFrameRate)
+	} else {
+		summary += " video=none"
+	}
+	if a := r.Audio(); a != nil {
+		summary += fmt.Sprintf(" audio=%s %sHz %dch", a.CodecName, a.SampleRate, a.Channels)
+	} else {
+		summary += " audio=none"
+	}
+	return summary
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,87 @@
+// Package profile defines the output formats normalize encodes clips to. Clips
+// are only joined with clips of the same profile, since the concat demuxer and
+// xfade both need every input to have the same size and frame rate.
+package profile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Profile names.
+const (
+	Landscape = "landscape"
+	Vertical  = "vertical"
+	Square    = "square"
+)
+
+// Default is the profile of clips normalized before profiles existed, which were
+// all letterboxed to 720p.
+const Default = Landscape
+
+// Fill modes, for the space left when a video's aspect ratio doesn't match the profile's.
+const (
+	// FillPad fills it with black bars.
+	FillPad = "pad"
+	// FillBlur fills it with a blurred, zoomed in copy of the video.
+	FillBlur = "blur"
+)
+
+// Profile is the size and frame rate of a normalized clip.
+type Profile struct {
+	Name   string
+	Width  int
+	Height int
+	FPS    int
+}
+
+var profiles = map[string]Profile{
+	Landscape: {Name: Landscape, Width: 1280, Height: 720, FPS: 30},
+	Vertical:  {Name: Vertical, Width: 1080, Height: 1920, FPS: 30},
+	Square:    {Name: Square, Width: 1080, Height: 1080, FPS: 30},
+}
+
+// Lookup returns the profile called name.
+func Lookup(name string) (Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%q is not a profile, use %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Names returns every profile name in order.
+func Names() []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateFill reports whether fill is a supported fill mode.
+func ValidateFill(fill string) error {
+	if fill != FillPad && fill != FillBlur {
+		return fmt.Errorf("%q must be %q or %q", fill, FillPad, FillBlur)
+	}
+	return nil
+}
+
+// Filter returns the video filter that fits a video inside the profile without
+// cropping it, fills the rest with fill, and sets the profile's frame rate.
+func (p Profile) Filter(fill string) string {
+	size := fmt.Sprintf("%d:%d", p.Width, p.Height)
+	tail := fmt.Sprintf("setsar=1,fps=%d", p.FPS)
+	if fill == FillBlur {
+		// The background is scaled down before blurring, which is much cheaper than
+		// blurring at full size and looks the same
+		return fmt.Sprintf("split[bg][fg];"+
+			"[bg]scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d,boxblur=10:2,scale=%s,setsar=1[blurred];"+
+			"[fg]scale=%s:force_original_aspect_ratio=decrease,setsar=1[fitted];"+
+			"[blurred][fitted]overlay=(W-w)/2:(H-h)/2,%s",
+			p.Width/4, p.Height/4, p.Width/4, p.Height/4, size, size, tail)
+	}
+	return fmt.Sprintf("scale=%s:force_original_aspect_ratio=decrease,pad=%s:(ow-iw)/2:(oh-ih)/2,%s", size, size, tail)
+}
@@ -0,0 +1,168 @@
+// Package telemetry sets up OpenTelemetry metrics and traces for the services.
+//
+// Exporters are chosen with the standard OTEL_METRICS_EXPORTER and
+// OTEL_TRACES_EXPORTER variables: "otlp" sends to the collector at
+// OTEL_EXPORTER_OTLP_ENDPOINT over HTTP, "console" writes JSON to stdout and
+// "none", the default, records nothing. Services create their instruments
+// from the global otel.Meter and otel.Tracer, which pick up the providers
+// Setup installs even if the instruments were created first.
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Exporter names accepted in OTEL_METRICS_EXPORTER and OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone    = "none"
+	ExporterOTLP    = "otlp"
+	ExporterConsole = "console"
+)
+
+// DurationBuckets are the histogram boundaries, in seconds, for yt-dlp, ffmpeg and
+// Cloud Storage calls, which run from under a second to several minutes.
+var DurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1200}
+
+var (
+	meterProvider  *sdkmetric.MeterProvider
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// Setup installs the global meter and tracer providers for service and returns
+// a function that flushes and stops them. With both exporters set to none it
+// installs nothing and the global no-op providers stay in place.
+func Setup(ctx context.Context, service string) (shutdown func(context.Context) error, err error) {
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(semconv.ServiceName(service)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("resource.New: %v", err)
+	}
+
+	metricReader, err := newMetricReader(ctx, env("OTEL_METRICS_EXPORTER"))
+	if err != nil {
+		return nil, err
+	}
+	if metricReader != nil {
+		meterProvider = sdkmetric.NewMeterProvider(sdkmetric.WithResource(res), sdkmetric.WithReader(metricReader))
+		otel.SetMeterProvider(meterProvider)
+	}
+
+	spanExporter, err := newSpanExporter(ctx, env("OTEL_TRACES_EXPORTER"))
+	if err != nil {
+		return nil, err
+	}
+	if spanExporter != nil {
+		tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithResource(res), sdktrace.WithBatcher(spanExporter))
+		otel.SetTracerProvider(tracerProvider)
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	}
+
+	return func(ctx context.Context) error {
+		var errs []error
+		if meterProvider != nil {
+			errs = append(errs, meterProvider.Shutdown(ctx))
+		}
+		if tracerProvider != nil {
+			errs = append(errs, tracerProvider.Shutdown(ctx))
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+// Flush exports everything recorded so far. Cloud Functions throttle the CPU between
+// invocations and never shut down cleanly, so the functions flush before returning.
+func Flush(ctx context.Context) {
+	if meterProvider != nil {
+		if err := meterProvider.ForceFlush(ctx); err != nil {
+			otel.Handle(err)
+		}
+	}
+	if tracerProvider != nil {
+		if err := tracerProvider.ForceFlush(ctx); err != nil {
+			otel.Handle(err)
+		}
+	}
+}
+
+// Seconds returns the time since start in seconds, for duration histograms.
+func Seconds(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Must wraps the creation of a service's instruments. It reports an error to
+// OpenTelemetry's error handler, since the instrument returned alongside an error
+// still works, it just may not be exported.
+func Must[T any](instrument T, err error) T {
+	if err != nil {
+		otel.Handle(err)
+	}
+	return instrument
+}
+
+func newMetricReader(ctx context.Context, exporter string) (sdkmetric.Reader, error) {
+	switch exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		e, err := otlpmetrichttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlpmetrichttp.New: %v", err)
+		}
+		return sdkmetric.NewPeriodicReader(e), nil
+	case ExporterConsole:
+		e, err := stdoutmetric.New()
+		if err != nil {
+			return nil, fmt.Errorf("stdoutmetric.New: %v", err)
+		}
+		return sdkmetric.NewPeriodicReader(e), nil
+	default:
+		return nil, fmt.Errorf("OTEL_METRICS_EXPORTER: unsupported exporter %q, use %s, %s or %s", exporter, ExporterOTLP, ExporterConsole, ExporterNone)
+	}
+}
+
+func newSpanExporter(ctx context.Context, exporter string) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+		e, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("otlptracehttp.New: %v", err)
+		}
+		return e, nil
+	case ExporterConsole:
+		e, err := stdouttrace.New()
+		if err != nil {
+			return nil, fmt.Errorf("stdouttrace.New: %v", err)
+		}
+		return e, nil
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER: unsupported exporter %q, use %s, %s or %s", exporter, ExporterOTLP, ExporterConsole, ExporterNone)
+	}
+}
+
+// env returns the exporter named by the variable, defaulting to none.
+func env(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return ExporterNone
+}
@@ -0,0 +1,49 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Start starts a span. A span without a parent joins the trace named by the
+// context's correlation ID, which is a valid trace ID, so the spans for a meme
+// line up with its log entries in every service.
+func Start(ctx context.Context, tracer trace.Tracer, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(correlatedParent(ctx), name, opts...)
+}
+
+// End records err on the span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// correlatedParent returns ctx with a remote parent span in the correlation ID's
+// trace, unless ctx already has a span or no usable ID.
+func correlatedParent(ctx context.Context) context.Context {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	traceID, err := trace.TraceIDFromHex(logging.ID(ctx))
+	if err != nil {
+		return ctx
+	}
+	// The parent only exists to carry the trace ID, so its span ID is made up
+	var spanID trace.SpanID
+	if _, err := rand.Read(spanID[:]); err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	}))
+}
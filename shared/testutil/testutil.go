@@ -0,0 +1,51 @@
+// Package testutil has the fixtures the service tests share: stand-ins for the
+// command line tools the handlers run, and a store in a temporary directory.
+package testutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+)
+
+// FFprobe describes every file as a three second 1080p h264 and aac mp4.
+const FFprobe = `#!/bin/sh
+echo '{"streams":[{"index":0,"codec_name":"h264","codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","nb_frames":"90","duration":"3"},{"index":1,"codec_name":"aac","codec_type":"audio","sample_rate":"48000","channels":2,"duration":"3"}],"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2","duration":"3","nb_streams":2}}'
+`
+
+// FFmpeg writes a small file to its last argument, unless that is - for a null
+// output, and reports the end of the run like -progress pipe:1. When
+// $FAKE_FFMPEG_ARGS names a file, each run appends its arguments to it as a line.
+const FFmpeg = `#!/bin/sh
+[ -n "$FAKE_FFMPEG_ARGS" ] && echo "$@" >> "$FAKE_FFMPEG_ARGS"
+for last; do :; done
+case "$last" in -) ;; *) head -c 1000 /dev/zero > "$last";; esac
+printf 'progress=end\n'
+`
+
+// Bin writes each script to a temporary directory as an executable named by its key
+// and puts the directory first on PATH for the rest of the test. It returns the
+// directory, so a test can also point a configured binary path into it.
+func Bin(t testing.TB, scripts map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// Store returns a local store in a temporary directory.
+func Store(t testing.TB) *blobstore.Local {
+	t.Helper()
+	store, err := blobstore.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
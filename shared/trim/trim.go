@@ -0,0 +1,166 @@
+// Package trim handles the part of a video a submitter picks with start and end
+// timestamps. The range is checked when the video is submitted, travels to normalize
+// in the object metadata, and is cut there with ffmpeg.
+package trim
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+)
+
+// Range is part of a video in seconds from its start. A zero End runs to the end of
+// the video, so the zero Range is the whole video.
+type Range struct {
+	Start float64
+	End   float64
+}
+
+// Parse parses the start and end timestamps of a range. Either may be empty.
+func Parse(start, end string) (Range, error) {
+	var r Range
+	var err error
+	if start = strings.TrimSpace(start); start != "" {
+		if r.Start, err = ParseTimestamp(start); err != nil {
+			return Range{}, fmt.Errorf("start: %v", err)
+		}
+	}
+	if end = strings.TrimSpace(end); end != "" {
+		if r.End, err = ParseTimestamp(end); err != nil {
+			return Range{}, fmt.Errorf("end: %v", err)
+		}
+		if r.End <= r.Start {
+			return Range{}, fmt.Errorf("end %s must be after start %s", Format(r.End), Format(r.Start))
+		}
+	}
+	return r, nil
+}
+
+// ParseTimestamp parses a timestamp in seconds, like 95 or 95.5, or in minutes and
+// seconds and optionally hours separated by colons, like 1:35 or 1:01:35.5.
+func ParseTimestamp(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("%q is not a timestamp, use seconds or [h:]mm:ss", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var value float64
+		var err error
+		if last {
+			value, err = strconv.ParseFloat(part, 64)
+		} else {
+			var n int
+			n, err = strconv.Atoi(part)
+			value = float64(n)
+		}
+		// Minutes and seconds after a colon can't roll over into the next unit
+		if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) || (i > 0 && value >= 60) {
+			return 0, fmt.Errorf("%q is not a timestamp, use seconds or [h:]mm:ss", s)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// Format formats seconds as a timestamp ParseTimestamp reads, like 1:35 or 1:01:35.5.
+func Format(seconds float64) string {
+	// Round once to milliseconds before splitting into units, so 59.9996 is 1:00
+	// rather than 0:59 and a fraction that rounds up to a whole second
+	ms := int64(math.Round(seconds * 1000))
+	whole := ms / 1000
+	frac := ""
+	if ms%1000 != 0 {
+		frac = strings.TrimRight(fmt.Sprintf(".%03d", ms%1000), "0")
+	}
+	if whole >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d%s", whole/3600, whole/60%60, whole%60, frac)
+	}
+	return fmt.Sprintf("%d:%02d%s", whole/60, whole%60, frac)
+}
+
+// IsZero reports whether the range is the whole video.
+func (r Range) IsZero() bool {
+	return r.Start == 0 && r.End == 0
+}
+
+func (r Range) String() string {
+	if r.End == 0 {
+		return Format(r.Start) + "-end"
+	}
+	return Format(r.Start) + "-" + Format(r.End)
+}
+
+// Length returns how long the range is in a video of duration seconds.
+func (r Range) Length(duration float64) float64 {
+	end := duration
+	if r.End > 0 && r.End < duration {
+		end = r.End
+	}
+	return math.Max(end-r.Start, 0)
+}
+
+// Check reports whether the range starts inside a video of duration seconds. A zero
+// duration is unknown and passes. An end past the video's end is cut to fit.
+func (r Range) Check(duration float64) error {
+	if duration > 0 && r.Start >= duration {
+		return fmt.Errorf("start %s is past the end of the video at %s", Format(r.Start), Format(duration))
+	}
+	return nil
+}
+
+// Cap shortens the range to at most max seconds in a video of duration seconds, and
+// reports whether it did. A zero max leaves it as it is.
+func (r Range) Cap(duration, max float64) (Range, bool) {
+	if max <= 0 || r.Length(duration) <= max {
+		return r, false
+	}
+	return Range{Start: r.Start, End: r.Start + max}, true
+}
+
+// InputArgs returns the ffmpeg input options that read only the range. They go before
+// the -i they apply to, which makes ffmpeg seek instead of decoding up to the start.
+func (r Range) InputArgs() []string {
+	var args []string
+	if r.Start > 0 {
+		args = append(args, "-ss", strconv.FormatFloat(r.Start, 'f', -1, 64))
+	}
+	if r.End > 0 {
+		args = append(args, "-to", strconv.FormatFloat(r.End, 'f', -1, 64))
+	}
+	return args
+}
+
+// Metadata adds the range to object metadata, or does nothing for the whole video.
+func (r Range) Metadata(m map[string]string) {
+	if r.Start > 0 {
+		m[metadata.KeyTrimStart] = metadata.FormatDuration(r.Start)
+	}
+	if r.End > 0 {
+		m[metadata.KeyTrimEnd] = metadata.FormatDuration(r.End)
+	}
+}
+
+// FromMetadata reads the range Metadata wrote. Missing keys are the whole video.
+func FromMetadata(m map[string]string) (Range, error) {
+	var r Range
+	for key, value := range map[string]*float64{metadata.KeyTrimStart: &r.Start, metadata.KeyTrimEnd: &r.End} {
+		raw := m[key]
+		if raw == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return Range{}, fmt.Errorf("%s %q is not a number of seconds", key, raw)
+		}
+		*value = f
+	}
+	if r.End > 0 && r.End <= r.Start {
+		return Range{}, fmt.Errorf("%s %s must be after %s %s", metadata.KeyTrimEnd, m[metadata.KeyTrimEnd], metadata.KeyTrimStart, m[metadata.KeyTrimStart])
+	}
+	return r, nil
+}
@@ -0,0 +1,169 @@
+package trim
+
+import (
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"95", 95, false},
+		{"95.5", 95.5, false},
+		{"0", 0, false},
+		{"1:35", 95, false},
+		{"01:35.25", 95.25, false},
+		{"1:01:35.5", 3695.5, false},
+		// Seconds alone may run past a minute, but not after a colon
+		{"0:90", 0, true},
+		{"1:60:00", 0, true},
+		{"1:2:3:4", 0, true},
+		{"-5", 0, true},
+		{"1.5:00", 0, true},
+		{"", 0, true},
+		{"Inf", 0, true},
+		{"NaN", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       Range
+		wantErr    bool
+	}{
+		{"", "", Range{}, false},
+		{" 5 ", "", Range{Start: 5}, false},
+		{"", "0:30", Range{End: 30}, false},
+		{"1:00", "1:30.5", Range{Start: 60, End: 90.5}, false},
+		{"10", "5", Range{}, true},
+		{"10", "10", Range{}, true},
+		{"soon", "", Range{}, true},
+		{"", "later", Range{}, true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.start, tt.end)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Parse(%q, %q) = %+v, %v, want %+v, error %v", tt.start, tt.end, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "0:00"},
+		{5, "0:05"},
+		{95, "1:35"},
+		{95.5, "1:35.5"},
+		{95.25, "1:35.25"},
+		{3695.5, "1:01:35.5"},
+		{0.0004, "0:00"},
+		// A fraction that rounds up carries into the seconds and minutes
+		{59.9996, "1:00"},
+		{3599.9999, "1:00:00"},
+		{1.2345, "0:01.235"},
+	}
+	for _, tt := range tests {
+		got := Format(tt.seconds)
+		if got != tt.want {
+			t.Errorf("Format(%v) = %q, want %q", tt.seconds, got, tt.want)
+		}
+		// Whatever Format writes, ParseTimestamp reads
+		if _, err := ParseTimestamp(got); err != nil {
+			t.Errorf("ParseTimestamp(Format(%v)): %v", tt.seconds, err)
+		}
+	}
+}
+
+func TestRangeCheck(t *testing.T) {
+	tests := []struct {
+		r        Range
+		duration float64
+		wantErr  bool
+	}{
+		{Range{}, 30, false},
+		{Range{Start: 10, End: 60}, 30, false},
+		{Range{Start: 30}, 30, true},
+		{Range{Start: 45}, 30, true},
+		// An unknown duration can't be checked
+		{Range{Start: 45}, 0, false},
+	}
+	for _, tt := range tests {
+		if err := tt.r.Check(tt.duration); (err != nil) != tt.wantErr {
+			t.Errorf("%v.Check(%v) = %v, want error %v", tt.r, tt.duration, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRangeCap(t *testing.T) {
+	tests := []struct {
+		name       string
+		r          Range
+		duration   float64
+		max        float64
+		want       Range
+		wantCapped bool
+	}{
+		{"no cap", Range{}, 300, 0, Range{}, false},
+		{"short enough", Range{}, 30, 60, Range{}, false},
+		{"whole video", Range{}, 300, 60, Range{End: 60}, true},
+		{"from the start", Range{Start: 10}, 300, 60, Range{Start: 10, End: 70}, true},
+		{"picked range", Range{Start: 10, End: 200}, 300, 60, Range{Start: 10, End: 70}, true},
+		// The end past the video's end only counts up to the end
+		{"end past the video", Range{Start: 10, End: 500}, 60, 60, Range{Start: 10, End: 500}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, capped := tt.r.Cap(tt.duration, tt.max)
+			if got != tt.want || capped != tt.wantCapped {
+				t.Errorf("Cap(%v, %v) = %+v, %v, want %+v, %v", tt.duration, tt.max, got, capped, tt.want, tt.wantCapped)
+			}
+		})
+	}
+}
+
+func TestFromMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       map[string]string
+		want    Range
+		wantErr bool
+	}{
+		{"none", map[string]string{}, Range{}, false},
+		{"start", map[string]string{metadata.KeyTrimStart: "1.500"}, Range{Start: 1.5}, false},
+		{"both", map[string]string{metadata.KeyTrimStart: "1.000", metadata.KeyTrimEnd: "3.500"}, Range{Start: 1, End: 3.5}, false},
+		{"not a number", map[string]string{metadata.KeyTrimStart: "1:00"}, Range{}, true},
+		{"negative", map[string]string{metadata.KeyTrimEnd: "-1"}, Range{}, true},
+		{"end before start", map[string]string{metadata.KeyTrimStart: "5", metadata.KeyTrimEnd: "2"}, Range{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FromMetadata(tt.m)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("FromMetadata = %+v, %v, want %+v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+	// Metadata writes what FromMetadata reads
+	r := Range{Start: 1.25, End: 3.5}
+	m := map[string]string{}
+	r.Metadata(m)
+	if got, err := FromMetadata(m); err != nil || got != r {
+		t.Errorf("FromMetadata(%v) = %+v, %v, want %+v", m, got, err, r)
+	}
+}
@@ -0,0 +1,328 @@
+// Package concatenator implements the concatenate function: it joins the
+// normalized videos into a single compilation.
+package concatenator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/probe"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// CompilationRequest is the optional JSON body. Empty fields use the configured defaults.
+type CompilationRequest struct {
+	// Mode is "copy" or "reencode".
+	Mode string `json:"mode,omitempty"`
+	// Transition is an xfade transition name, used in reencode mode.
+	Transition     string   `json:"transition,omitempty"`
+	Crossfade      *float64 `json:"crossfade,omitempty"`
+	AudioCrossfade *float64 `json:"audioCrossfade,omitempty"`
+	// Attribution, Intro and Outro override the configured credits and title cards.
+	Attribution *bool   `json:"attribution,omitempty"`
+	Intro       *string `json:"intro,omitempty"`
+	Outro       *string `json:"outro,omitempty"`
+	// Profile picks which clips are joined, since only clips of the same profile can be.
+	Profile string `json:"profile,omitempty"`
+	// Preset is the encoder speed and quality tier, used in reencode mode.
+	Preset string `json:"preset,omitempty"`
+}
+
+// compilationOptions are the settings for a single compilation.
+type compilationOptions struct {
+	Mode        string
+	Transition  config.Transition
+	Attribution config.Attribution
+	Profile     profile.Profile
+	Preset      encoder.Preset
+}
+
+// options merges the request over the configured defaults and validates the result.
+func (req *CompilationRequest) options(ctx context.Context, defaults config.Concatenate) (*compilationOptions, error) {
+	opts := &compilationOptions{
+		Mode:        defaults.Mode,
+		Transition:  defaults.Transition,
+		Attribution: defaults.Attribution,
+	}
+
+	if req.Mode != "" {
+		opts.Mode = req.Mode
+	}
+	if err := config.ValidateConcatMode(opts.Mode); err != nil {
+		return nil, fmt.Errorf("mode: %v", err)
+	}
+
+	if req.Transition != "" {
+		opts.Transition.Name = req.Transition
+	}
+	if req.Crossfade != nil {
+		opts.Transition.Duration = *req.Crossfade
+	}
+	if req.AudioCrossfade != nil {
+		opts.Transition.AudioDuration = *req.AudioCrossfade
+	}
+	if errs := opts.Transition.Validate(); len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	name := defaults.Profile
+	if req.Profile != "" {
+		name = req.Profile
+	}
+	p, err := profile.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("profile: %v", err)
+	}
+	opts.Profile = p
+
+	name = defaults.Preset
+	if req.Preset != "" {
+		name = req.Preset
+	}
+	preset, err := encoder.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("preset: %v", err)
+	}
+	opts.Preset = preset
+
+	if req.Attribution != nil {
+		opts.Attribution.Enabled = *req.Attribution
+	}
+	if req.Intro != nil {
+		opts.Attribution.IntroText = *req.Intro
+	}
+	if req.Outro != nil {
+		opts.Attribution.OutroText = *req.Outro
+	}
+
+	// Text can only be burned in while re-encoding
+	if opts.Attribution.Overlays() && opts.Mode == config.ConcatModeCopy {
+		slog.InfoContext(ctx, "Attribution or title cards requested, switching to reencode mode")
+		opts.Mode = config.ConcatModeReencode
+	}
+	return opts, nil
+}
+
+// workStatus is the status for work that failed: 504 when it ran out of time,
+// otherwise 500.
+func workStatus(work context.Context) int {
+	if errors.Is(work.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeErrorResponse(w http.ResponseWriter, message string, code int) {
+	w.WriteHeader(code)
+	errorResponse := ErrorResponse{Error: message}
+	json.NewEncoder(w).Encode(errorResponse)
+}
+
+// Service builds compilations from the normalized bucket into the compilations bucket on Store.
+type Service struct {
+	Store  blobstore.Store
+	Config *config.Config
+}
+
+// New creates a concatenate service from a validated configuration.
+func New(store blobstore.Store, cfg *config.Config) *Service {
+	return &Service{Store: store, Config: cfg}
+}
+
+// ConcatenateVideos is the HTTP entry point that creates a compilation. The compilation
+// logs under the request's correlation ID, set by logging.Middleware, and each clip's
+// entries under the ID it was submitted with. The work stops when the client disconnects,
+// or CLEANUP_RESERVE before CONCATENATE_TIMEOUT so there is time left to upload.
+func (s *Service) ConcatenateVideos(w http.ResponseWriter, r *http.Request) {
+	compilationID := logging.ID(r.Context())
+	if compilationID == "" {
+		compilationID = logging.NewID()
+	}
+	ctx, cancel := deadline.WithTimeout(logging.WithID(r.Context(), compilationID), s.Config.Concatenate.TimeoutDuration())
+	defer cancel()
+	ctx, span := telemetry.Start(ctx, tracer, "concatenate")
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	defer func() {
+		recordCompilation(ctx, rec.status, start)
+		span.SetAttributes(attribute.Int("status", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+		span.End()
+	}()
+	normalizedVideoBucket := s.Config.Buckets.Normalized
+	compilationsBucket := s.Config.Buckets.Compilations
+
+	// The body is optional; an empty one uses the configured defaults
+	var req CompilationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeErrorResponse(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	opts, err := req.options(ctx, s.Config.Concatenate)
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// The minimum number of videos comes from MIN_VIDEOS, validated at startup
+	minVideos := s.Config.Concatenate.MinVideos
+
+	// List every page of the "normalized" bucket and pick the clips for this compilation
+	listing, err := blobstore.ListAll(ctx, s.Store, normalizedVideoBucket, "")
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to list objects: %v", err), http.StatusInternalServerError)
+		return
+	}
+	objects, videoCount := selectClips(ctx, listing, s.Config.Concatenate.Selection, opts.Profile.Name)
+	queueDepth.Record(ctx, int64(videoCount), metric.WithAttributes(attribute.String("profile", opts.Profile.Name)))
+
+	// Check the selection rather than the eligible clips, since MAX_DURATION can cut
+	// it below MIN_VIDEOS when the clips are long
+	if len(objects) < minVideos {
+		writeErrorResponse(w, fmt.Sprintf("Not enough %s videos to create a compilation. Selected %d of %d videos, need at least %d.", opts.Profile.Name, len(objects), videoCount, minVideos), http.StatusNoContent)
+		return
+	}
+
+	// Create a temporary directory to store the downloaded videos
+	tempDir, err := os.MkdirTemp("", "normalized-videos")
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to create temporary directory: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Refuse the job up front rather than filling the in-memory temp directory mid-encode
+	if err := checkWorkspace(tempDir, workspaceEstimate(objects), s.Config.Concatenate.WorkspaceBudget); err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Insufficient workspace: %v", err), http.StatusInsufficientStorage)
+		return
+	}
+
+	// Downloading, probing and encoding stop early enough to leave time for the upload
+	work, cancelWork := deadline.Reserve(ctx, s.Config.CleanupReserveDuration())
+	defer cancelWork()
+
+	// Download the videos from the "normalized" bucket
+	videoFiles, err := s.fetchClips(work, normalizedVideoBucket, objects, tempDir, s.Config.Concatenate.Workers)
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to download videos: %v", err), workStatus(work))
+		return
+	}
+
+	outputFile := filepath.Join(tempDir, "output.mp4")
+
+	var args []string
+	if opts.Mode == config.ConcatModeReencode {
+		// Transitions need every clip's duration to place the crossfades
+		durations := make([]float64, len(videoFiles))
+		for i, videoFile := range videoFiles {
+			result, err := probe.Run(work, videoFile)
+			if err != nil {
+				writeErrorResponse(w, fmt.Sprintf("Failed to probe video: %v", err), workStatus(work))
+				return
+			}
+			durations[i] = result.Duration()
+		}
+
+		// Credit each clip with the attribution carried in its object metadata
+		credits := make([]string, len(objects))
+		for i, object := range objects {
+			credits[i] = metadata.ClipFromMetadata(object.Metadata).Credit()
+		}
+
+		segments := buildSegments(videoFiles, durations, credits, opts.Attribution)
+		args, err = reencodeArgs(segments, opts.Profile, opts.Preset, opts.Transition, opts.Attribution, tempDir, outputFile)
+		if err != nil {
+			writeErrorResponse(w, fmt.Sprintf("Failed to build ffmpeg command: %v", err), http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(ctx, "Re-encoding videos", "videos", len(videoFiles), "preset", opts.Preset.Name, "transition", opts.Transition.Name,
+			"crossfade", opts.Transition.Duration, "audioCrossfade", opts.Transition.AudioDuration, "attribution", opts.Attribution.Enabled)
+	} else {
+		// Create the video list file for ffmpeg
+		videoListFile := filepath.Join(tempDir, "videos-for-ffmpeg.txt")
+		file, err := os.Create(videoListFile)
+		if err != nil {
+			writeErrorResponse(w, fmt.Sprintf("Failed to create video list file: %v", err), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		for _, videoFile := range videoFiles {
+			fmt.Fprintf(file, "file '%s'\n", videoFile)
+		}
+		args = []string{"-f", "concat", "-safe", "0", "-i", videoListFile, "-c", "copy", outputFile}
+	}
+
+	// Run ffmpeg command to concatenate the videos together
+	if _, err := s.ffmpeg().Run(work, opts.Mode, args); err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to run ffmpeg command: %v", err), workStatus(work))
+		return
+	}
+
+	timestamp := time.Now().Format("20060102150405") // Format: YYYYMMDDHHmmss
+
+	// Stream the compilation video to the "compilation" bucket with the timestamp in the filename.
+	// The chunk size bounds how much of it is buffered in memory at once.
+	outputFileData, err := os.Open(outputFile)
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to read output file: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer outputFileData.Close()
+
+	objectName := fmt.Sprintf("compilation-%s.mp4", timestamp)
+	_, err = s.Store.Put(ctx, compilationsBucket, objectName, outputFileData, &blobstore.PutOptions{
+		ContentType: "video/mp4",
+		Metadata:    map[string]string{metadata.KeyCorrelationID: compilationID, metadata.KeyProfile: opts.Profile.Name},
+		ChunkSize:   s.Config.Concatenate.UploadChunkSize,
+	})
+	if err != nil {
+		writeErrorResponse(w, fmt.Sprintf("Failed to upload compilation video: %v", err), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "Compilation uploaded", "bucket", compilationsBucket, "object", objectName, "videos", len(objects), "profile", opts.Profile.Name)
+	clipsPerCompilation.Record(ctx, int64(len(objects)))
+
+	// Delete the normalized videos from the "normalized" bucket. Each clip logs under
+	// its own correlation ID, so searching for a meme's ID finds the compilation it went into.
+	// The compilation is uploaded, so finish even if the client has gone, or the clips
+	// would go into the next compilation as well.
+	cleanup, cancelCleanup := deadline.Cleanup(ctx, s.Config.CleanupReserveDuration())
+	defer cancelCleanup()
+	for _, object := range objects {
+		clipCtx := logging.WithID(cleanup, object.Metadata[metadata.KeyCorrelationID])
+		slog.InfoContext(clipCtx, "Clip included in compilation", "clip", object.Name, "compilation", objectName, "compilationCorrelationId", compilationID)
+		err := s.Store.Delete(cleanup, normalizedVideoBucket, object.Name)
+		if err != nil {
+			slog.ErrorContext(clipCtx, "Failed to delete object", "object", object.Name, "error", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Compilation video created and uploaded successfully.")
+}
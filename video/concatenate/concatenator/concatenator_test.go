@@ -0,0 +1,149 @@
+package concatenator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+)
+
+// newTestService returns a service on a local store that runs the fake ffmpeg and
+// needs two clips for a compilation.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	testutil.Bin(t, map[string]string{"ffmpeg": testutil.FFmpeg, "ffprobe": testutil.FFprobe})
+	cfg := config.Default()
+	cfg.Concatenate.MinVideos = 2
+	return New(testutil.Store(t), cfg)
+}
+
+// putClips writes n normalized clips of the given length, named clip-0.mp4 onwards.
+func putClips(t *testing.T, s *Service, n int, duration string) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		m := map[string]string{metadata.KeyDuration: duration}
+		if _, err := s.Store.Put(context.Background(), s.Config.Buckets.Normalized, fmt.Sprintf("clip-%d.mp4", i), strings.NewReader("clip"), &blobstore.PutOptions{ContentType: "video/mp4", Metadata: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcatenateVideosUploadsCompilation(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Config.Concatenate.Selection.MaxClips = 2
+	putClips(t, s, 3, "10")
+
+	rec := httptest.NewRecorder()
+	s.ConcatenateVideos(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "Compilation video created and uploaded successfully." {
+		t.Fatalf("response = %d %q, want %d", rec.Code, rec.Body, http.StatusAccepted)
+	}
+
+	compilations, err := blobstore.ListAll(ctx, s.Store, s.Config.Buckets.Compilations, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compilations) != 1 || !strings.HasPrefix(compilations[0].Name, "compilation-") {
+		t.Fatalf("compilations = %v, want one compilation", compilations)
+	}
+	if got := compilations[0].Metadata[metadata.KeyProfile]; got != s.Config.Concatenate.Profile {
+		t.Errorf("compilation profile = %q, want %q", got, s.Config.Concatenate.Profile)
+	}
+
+	// Only the clips that went in are deleted; the rest wait for the next compilation
+	for name, kept := range map[string]bool{"clip-0.mp4": false, "clip-1.mp4": false, "clip-2.mp4": true} {
+		_, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, name)
+		if kept && err != nil {
+			t.Errorf("%s was deleted: %v", name, err)
+		}
+		if !kept && !errors.Is(err, blobstore.ErrNotExist) {
+			t.Errorf("%s was not deleted: Stat = %v", name, err)
+		}
+	}
+}
+
+func TestConcatenateVideosNotEnoughVideos(t *testing.T) {
+	tests := []struct {
+		name        string
+		clips       int
+		maxDuration float64
+		message     string
+	}{
+		{"too few clips", 1, 0, "Selected 1 of 1 videos"},
+		// Two clips are eligible but only one fits in the duration
+		{"too long", 2, 15, "Selected 1 of 2 videos"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			s.Config.Concatenate.Selection.MaxDuration = tt.maxDuration
+			putClips(t, s, tt.clips, "10")
+
+			rec := httptest.NewRecorder()
+			s.ConcatenateVideos(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+			if rec.Code != http.StatusNoContent || !strings.Contains(rec.Body.String(), tt.message) {
+				t.Fatalf("response = %d %q, want %d with %q", rec.Code, rec.Body, http.StatusNoContent, tt.message)
+			}
+
+			listing, err := blobstore.ListAll(context.Background(), s.Store, s.Config.Buckets.Normalized, "")
+			if err != nil || len(listing) != tt.clips {
+				t.Errorf("normalized clips = %d, %v, want all %d kept", len(listing), err, tt.clips)
+			}
+		})
+	}
+}
+
+func TestCompilationRequestOptions(t *testing.T) {
+	float := func(f float64) *float64 { return &f }
+	yes := true
+	intro := "Memes of the week"
+	defaults := config.Default().Concatenate
+	defaults.Transition.Duration = 1
+
+	tests := []struct {
+		name       string
+		req        CompilationRequest
+		mode       string
+		transition config.Transition
+		wantErr    bool
+	}{
+		{"defaults", CompilationRequest{}, config.ConcatModeCopy, config.Transition{Name: "fade", Duration: 1}, false},
+		{
+			"overrides",
+			CompilationRequest{Mode: config.ConcatModeReencode, Transition: "wipeleft", Crossfade: float(0.5), AudioCrossfade: float(0.25)},
+			config.ConcatModeReencode, config.Transition{Name: "wipeleft", Duration: 0.5, AudioDuration: 0.25}, false,
+		},
+		// An explicit zero turns the configured crossfade off
+		{"no crossfade", CompilationRequest{Crossfade: float(0)}, config.ConcatModeCopy, config.Transition{Name: "fade"}, false},
+		// Text can only be drawn while re-encoding
+		{"attribution", CompilationRequest{Attribution: &yes}, config.ConcatModeReencode, config.Transition{Name: "fade", Duration: 1}, false},
+		{"intro card", CompilationRequest{Intro: &intro}, config.ConcatModeReencode, config.Transition{Name: "fade", Duration: 1}, false},
+		{"unknown mode", CompilationRequest{Mode: "splice"}, "", config.Transition{}, true},
+		{"unknown transition", CompilationRequest{Transition: "spin"}, "", config.Transition{}, true},
+		{"audio longer than video", CompilationRequest{AudioCrossfade: float(2)}, "", config.Transition{}, true},
+		{"crossfade too long", CompilationRequest{Crossfade: float(config.MaxTransitionDuration + 1)}, "", config.Transition{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.req.options(context.Background(), defaults)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("options error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if opts.Mode != tt.mode || opts.Transition != tt.transition {
+				t.Errorf("options = %s, %+v, want %s, %+v", opts.Mode, opts.Transition, tt.mode, tt.transition)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package concatenator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/errgroup"
+)
+
+// fetchClips downloads the objects into dir with at most workers downloads in flight.
+// The returned paths are in the same order as objects. The first failure cancels the
+// remaining downloads.
+func (s *Service) fetchClips(ctx context.Context, bucket string, objects []*blobstore.Attrs, dir string, workers int) (_ []string, err error) {
+	ctx, span := telemetry.Start(ctx, tracer, "fetch clips", trace.WithAttributes(
+		attribute.Int("clips", len(objects)),
+		attribute.Int("workers", workers)))
+	defer func() { telemetry.End(span, err) }()
+
+	videoFiles := make([]string, len(objects))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(workers)
+
+	for i, object := range objects {
+		videoFiles[i] = filepath.Join(dir, filepath.Base(object.Name))
+		videoFile := videoFiles[i]
+		name := object.Name
+		g.Go(func() error {
+			if err := s.fetchObject(ctx, bucket, name, videoFile); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return videoFiles, nil
+}
+
+// fetchObject streams a single object to path.
+func (s *Service) fetchObject(ctx context.Context, bucket, name, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("os.Create: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := s.Store.Get(ctx, bucket, name)
+	if err != nil {
+		return fmt.Errorf("Store.Get: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(file, reader); err != nil {
+		return fmt.Errorf("io.Copy: %v", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,71 @@
+package concatenator
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/ffmpeg"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/DC00/meme-compiler-cloud-functions/video/concatenate/concatenator"
+
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	compilations = telemetry.Must(meter.Int64Counter("concatenate.compilations",
+		metric.WithDescription("Concatenate requests by outcome (created, not_enough_videos or failed) and HTTP status.")))
+	compilationDuration = telemetry.Must(meter.Float64Histogram("concatenate.duration",
+		metric.WithDescription("Time to handle a concatenate request."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+	ffmpegDuration = telemetry.Must(meter.Float64Histogram("concatenate.ffmpeg.duration",
+		metric.WithDescription("ffmpeg runtime by mode (copy or reencode) and outcome."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+	clipsPerCompilation = telemetry.Must(meter.Int64Histogram("concatenate.clips",
+		metric.WithDescription("Clips in each compilation."),
+		metric.WithExplicitBucketBoundaries(5, 10, 20, 30, 40, 50, 75, 100)))
+	queueDepth = telemetry.Must(meter.Int64Gauge("concatenate.queue.depth",
+		metric.WithDescription("Eligible videos of the requested profile waiting in the normalized bucket, measured on every concatenate request.")))
+)
+
+// statusRecorder remembers the status code the handler wrote.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// recordCompilation records a request's outcome from its response status.
+func recordCompilation(ctx context.Context, status int, start time.Time) {
+	outcome := "failed"
+	switch status {
+	case http.StatusAccepted:
+		outcome = "created"
+	case http.StatusNoContent:
+		outcome = "not_enough_videos"
+	}
+	attrs := metric.WithAttributes(attribute.String("outcome", outcome), attribute.Int("status", status))
+	compilations.Add(ctx, 1, attrs)
+	compilationDuration.Record(ctx, telemetry.Seconds(start), attrs)
+}
+
+// ffmpeg returns the runner for the compilation's ffmpeg run, recorded by mode.
+func (s *Service) ffmpeg() *ffmpeg.Runner {
+	return &ffmpeg.Runner{
+		Tracer:   tracer,
+		Duration: ffmpegDuration,
+		StepKey:  "mode",
+		Timeout:  s.Config.FFmpeg.TimeoutDuration(),
+	}
+}
@@ -0,0 +1,202 @@
+package concatenator
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+// segment is one part of the compilation: a downloaded clip or a generated title card.
+type segment struct {
+	// file is the clip's path. Empty for a title card.
+	file     string
+	duration float64
+	// text is the clip's lower third credit or the card's title. Empty draws nothing.
+	text string
+}
+
+func (s *segment) card() bool {
+	return s.file == ""
+}
+
+// buildSegments orders the clips between the optional intro and outro cards.
+func buildSegments(videoFiles []string, durations []float64, credits []string, attribution config.Attribution) []segment {
+	var segments []segment
+	if attribution.IntroText != "" {
+		segments = append(segments, segment{duration: attribution.CardDuration, text: attribution.IntroText})
+	}
+	for i, videoFile := range videoFiles {
+		clip := segment{file: videoFile, duration: durations[i]}
+		if attribution.Enabled {
+			clip.text = credits[i]
+		}
+		segments = append(segments, clip)
+	}
+	if attribution.OutroText != "" {
+		segments = append(segments, segment{duration: attribution.CardDuration, text: attribution.OutroText})
+	}
+	return segments
+}
+
+// reencodeArgs builds the ffmpeg arguments that join the segments with a filter_complex
+// graph instead of the concat demuxer. Title cards are generated at the size and frame
+// rate of p, the clips' profile, and the result is encoded with preset. Overlay text is
+// written to files in textDir and read by drawtext, so user-supplied names never appear
+// in the filter graph itself.
+//
+// With a video crossfade of T seconds every segment overlaps the next by T. The audio
+// must overlap by the same amount to stay in sync, so each segment's audio is trimmed
+// by T minus the audio crossfade before the acrossfade (or plain concat) joins them.
+func reencodeArgs(segments []segment, p profile.Profile, preset encoder.Preset, transition config.Transition, attribution config.Attribution, textDir, outputFile string) ([]string, error) {
+	durations := make([]float64, len(segments))
+	for i, seg := range segments {
+		durations[i] = seg.duration
+	}
+	overlap, audioFade := transitionDurations(durations, transition)
+
+	var args, graph []string
+	input := 0
+	for i, seg := range segments {
+		var video, audio string
+		if seg.card() {
+			// A black frame and silence, drawn on below
+			args = append(args,
+				"-f", "lavfi", "-t", fmt.Sprintf("%.3f", seg.duration), "-i", fmt.Sprintf("color=c=black:s=%dx%d:r=%d", p.Width, p.Height, p.FPS),
+				"-f", "lavfi", "-t", fmt.Sprintf("%.3f", seg.duration), "-i", "anullsrc=channel_layout=stereo:sample_rate=48000")
+			video, audio = fmt.Sprintf("[%d:v]", input), fmt.Sprintf("[%d:a]", input+1)
+			input += 2
+		} else {
+			args = append(args, "-i", seg.file)
+			video, audio = fmt.Sprintf("[%d:v]", input), fmt.Sprintf("[%d:a]", input)
+			input++
+		}
+
+		// xfade needs identical timebases and formats on both inputs
+		videoFilter := fmt.Sprintf("%ssettb=AVTB,fps=%d,format=yuv420p,setsar=1", video, p.FPS)
+		if seg.text != "" {
+			textFile := filepath.Join(textDir, fmt.Sprintf("overlay-%d.txt", i))
+			if err := os.WriteFile(textFile, []byte(seg.text), 0o644); err != nil {
+				return nil, fmt.Errorf("os.WriteFile: %v", err)
+			}
+			videoFilter += "," + drawtext(textFile, seg.card(), attribution)
+		}
+		graph = append(graph, fmt.Sprintf("%s[v%d]", videoFilter, i))
+
+		audioFilter := fmt.Sprintf("%saresample=48000,aformat=channel_layouts=stereo", audio)
+		if trim := overlap - audioFade; trim > 0 && i < len(segments)-1 {
+			audioFilter += fmt.Sprintf(",atrim=end=%.3f,asetpts=PTS-STARTPTS", seg.duration-trim)
+		}
+		graph = append(graph, fmt.Sprintf("%s[a%d]", audioFilter, i))
+	}
+
+	graph = append(graph, videoChain(len(segments), durations, transition.Name, overlap)...)
+	graph = append(graph, audioChain(len(segments), audioFade)...)
+
+	args = append(args,
+		"-filter_complex", strings.Join(graph, ";"),
+		"-map", "[vout]", "-map", "[aout]")
+	args = append(args, preset.VideoArgs()...)
+	args = append(args, encoder.AudioArgs()...)
+	args = append(args, "-movflags", "+faststart", outputFile)
+	return args, nil
+}
+
+// drawtext returns the filter for a clip's lower third or a card's centered title.
+func drawtext(textFile string, card bool, attribution config.Attribution) string {
+	options := []string{
+		fmt.Sprintf("textfile='%s'", filterEscape(textFile)),
+		// drawtext expands %{...} sequences in the text, so a name with a % in it
+		// could fail the filter or print something else
+		"expansion=none",
+		"fontcolor=white",
+	}
+	if attribution.FontFile != "" {
+		options = append(options, fmt.Sprintf("fontfile='%s'", filterEscape(attribution.FontFile)))
+	}
+
+	if card {
+		options = append(options,
+			fmt.Sprintf("fontsize=%d", attribution.FontSize*2),
+			"x=(w-tw)/2", "y=(h-th)/2")
+	} else {
+		options = append(options,
+			fmt.Sprintf("fontsize=%d", attribution.FontSize),
+			"box=1", "boxcolor=black@0.6", "boxborderw=12",
+			"x=40", "y=h-th-60",
+			fmt.Sprintf("enable='between(t,0,%.3f)'", attribution.Duration))
+	}
+	return "drawtext=" + strings.Join(options, ":")
+}
+
+// filterEscape escapes a value for use inside single quotes in a filter graph.
+func filterEscape(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// transitionDurations clamps the configured crossfades so no transition is longer
+// than half of the shortest segment.
+func transitionDurations(durations []float64, transition config.Transition) (float64, float64) {
+	if len(durations) < 2 {
+		return 0, 0
+	}
+	shortest := math.Inf(1)
+	for _, d := range durations {
+		shortest = math.Min(shortest, d)
+	}
+	overlap := math.Min(transition.Duration, shortest/2)
+	audioFade := math.Min(transition.AudioDuration, overlap)
+	return overlap, audioFade
+}
+
+func videoChain(n int, durations []float64, name string, overlap float64) []string {
+	if overlap <= 0 {
+		var inputs string
+		for i := 0; i < n; i++ {
+			inputs += fmt.Sprintf("[v%d]", i)
+		}
+		return []string{fmt.Sprintf("%sconcat=n=%d:v=1:a=0[vout]", inputs, n)}
+	}
+
+	var graph []string
+	previous := "v0"
+	offset := 0.0
+	for i := 1; i < n; i++ {
+		// Each transition starts overlap seconds before the end of everything joined so far
+		offset += durations[i-1] - overlap
+		out := fmt.Sprintf("vx%d", i)
+		if i == n-1 {
+			out = "vout"
+		}
+		graph = append(graph, fmt.Sprintf("[%s][v%d]xfade=transition=%s:duration=%.3f:offset=%.3f[%s]", previous, i, name, overlap, offset, out))
+		previous = out
+	}
+	return graph
+}
+
+func audioChain(n int, audioFade float64) []string {
+	if audioFade <= 0 || n < 2 {
+		var inputs string
+		for i := 0; i < n; i++ {
+			inputs += fmt.Sprintf("[a%d]", i)
+		}
+		return []string{fmt.Sprintf("%sconcat=n=%d:v=0:a=1[aout]", inputs, n)}
+	}
+
+	var graph []string
+	previous := "a0"
+	for i := 1; i < n; i++ {
+		out := fmt.Sprintf("ax%d", i)
+		if i == n-1 {
+			out = "aout"
+		}
+		graph = append(graph, fmt.Sprintf("[%s][a%d]acrossfade=d=%.3f[%s]", previous, i, audioFade, out))
+		previous = out
+	}
+	return graph
+}
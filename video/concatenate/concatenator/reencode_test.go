@@ -0,0 +1,53 @@
+package concatenator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+func TestReencodeArgsDrawsTextVerbatim(t *testing.T) {
+	p, err := profile.Lookup(profile.Default)
+	if err != nil {
+		t.Fatal(err)
+	}
+	preset, err := encoder.Lookup(encoder.Fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attribution := config.Attribution{Enabled: true, Duration: 4, FontSize: 36}
+
+	const credit = "100% real %{localtime} on youtube\nsubmitted by it's:me"
+	textDir := t.TempDir()
+	segments := []segment{{file: "clip.mp4", duration: 10, text: credit}}
+	args, err := reencodeArgs(segments, p, preset, config.Transition{}, attribution, textDir, "out.mp4")
+	if err != nil {
+		t.Fatalf("reencodeArgs: %v", err)
+	}
+
+	var graph string
+	for i, arg := range args {
+		if arg == "-filter_complex" && i+1 < len(args) {
+			graph = args[i+1]
+		}
+	}
+	if !strings.Contains(graph, "expansion=none") {
+		t.Errorf("filter graph %q does not turn off drawtext expansion", graph)
+	}
+	if strings.Contains(graph, "localtime") || strings.Contains(graph, "100%") {
+		t.Errorf("filter graph %q contains the overlay text", graph)
+	}
+
+	text, err := os.ReadFile(filepath.Join(textDir, "overlay-0.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(text) != credit {
+		t.Errorf("overlay text = %q, want %q", text, credit)
+	}
+}
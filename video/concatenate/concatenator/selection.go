@@ -0,0 +1,95 @@
+package concatenator
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+// eligible reports whether an object looks like a normalized video within the size bounds.
+func eligible(object *blobstore.Attrs, selection config.Selection) bool {
+	isVideo := strings.HasPrefix(object.ContentType, "video/")
+	for _, ext := range selection.Extensions {
+		if strings.EqualFold(path.Ext(object.Name), ext) {
+			isVideo = true
+		}
+	}
+	if !isVideo {
+		return false
+	}
+	if object.Size < selection.MinSize {
+		return false
+	}
+	if selection.MaxSize > 0 && object.Size > selection.MaxSize {
+		return false
+	}
+	return true
+}
+
+// clipProfile returns the profile a clip was normalized to. Clips normalized before
+// profiles were recorded are all the default.
+func clipProfile(m map[string]string) string {
+	if p := m[metadata.KeyProfile]; p != "" {
+		return p
+	}
+	return profile.Default
+}
+
+// selectClips filters the listing down to usable videos of the profile and picks the
+// oldest ones until MaxClips or MaxDuration is reached. It returns the selection and
+// how many objects were eligible. Ties on creation time are broken by name so the same
+// bucket always yields the same compilation.
+func selectClips(ctx context.Context, objects []*blobstore.Attrs, selection config.Selection, profileName string) ([]*blobstore.Attrs, int) {
+	var candidates []*blobstore.Attrs
+	otherProfiles := 0
+	for _, object := range objects {
+		// Clips of other profiles wait for a compilation of their own
+		if clipProfile(object.Metadata) != profileName {
+			otherProfiles++
+			continue
+		}
+		// Near-duplicates flagged by normalize stay in the bucket for review but never go in
+		if original := object.Metadata[metadata.KeyDuplicateOf]; original != "" {
+			slog.InfoContext(ctx, "Skipping near-duplicate", "object", object.Name, "duplicateOf", original)
+			continue
+		}
+		if eligible(object, selection) {
+			candidates = append(candidates, object)
+		} else {
+			slog.InfoContext(ctx, "Skipping ineligible object", "object", object.Name, "contentType", object.ContentType, "size", object.Size)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].Created.Equal(candidates[j].Created) {
+			return candidates[i].Created.Before(candidates[j].Created)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	var selected []*blobstore.Attrs
+	total := 0.0
+	for _, object := range candidates {
+		if len(selected) == selection.MaxClips {
+			break
+		}
+		// Objects normalized before durations were recorded count as zero
+		duration := metadata.Duration(object.Metadata)
+		if selection.MaxDuration > 0 && len(selected) > 0 && total+duration > selection.MaxDuration {
+			break
+		}
+		selected = append(selected, object)
+		total += duration
+	}
+
+	slog.InfoContext(ctx, "Selected videos", "profile", profileName, "selected", len(selected), "eligible", len(candidates),
+		"otherProfiles", otherProfiles, "duration", total)
+	return selected, len(candidates)
+}
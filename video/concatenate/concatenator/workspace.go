@@ -0,0 +1,38 @@
+package concatenator
+
+import (
+	"fmt"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+)
+
+// workspaceEstimate is the scratch space a compilation needs: every input plus an
+// output about as large as all of them together.
+func workspaceEstimate(objects []*blobstore.Attrs) int64 {
+	var inputs int64
+	for _, object := range objects {
+		inputs += object.Size
+	}
+	return 2 * inputs
+}
+
+// checkWorkspace fails when the estimate won't fit in the budget, or in the free space
+// of dir when no budget is configured. On Cloud Functions the temp directory is held in
+// memory, so running out of it gets the instance killed rather than returning an error.
+func checkWorkspace(dir string, need, budget int64) error {
+	limit := budget
+	source := "WORKSPACE_BUDGET"
+	if limit == 0 {
+		available, ok := availableBytes(dir)
+		if !ok {
+			return nil
+		}
+		limit = available
+		source = fmt.Sprintf("free space in %s", dir)
+	}
+
+	if need > limit {
+		return fmt.Errorf("compilation needs about %d MiB of scratch space but only %d MiB is available (%s); lower MAX_CLIPS or MAX_DURATION, or raise the function's memory", need>>20, limit>>20, source)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package concatenator
+
+// availableBytes can't measure free space on this platform, so the check is skipped.
+func availableBytes(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,418 @@
+// Package downloader implements the download service: it fetches a submitted
+// video with yt-dlp and uploads it to the quarantine bucket.
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/probe"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/trim"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/jobs"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/proxy"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/ytdlp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Submission struct {
+	URL     string `json:"url"`
+	Webhook string `json:"webhook"`
+	// Submitter is the Discord user who submitted the video, shown in the compilation credits.
+	Submitter string `json:"submitter,omitempty"`
+	// Start and End are timestamps picking the part of the video that becomes the clip,
+	// like 1:35 or 95.5. Either may be empty.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+}
+
+// Range returns the part of the video the submission picked.
+func (s Submission) Range() (trim.Range, error) {
+	return trim.Parse(s.Start, s.End)
+}
+
+// Service downloads submissions into the quarantine bucket on Store and tracks
+// each one as a job in Jobs.
+type Service struct {
+	Store  blobstore.Store
+	Jobs   jobs.Store
+	Config *config.Config
+	// Proxies are used in turn for yt-dlp. An empty pool connects directly.
+	Proxies *proxy.Pool
+
+	// running counts jobs that have been accepted but not finished.
+	running sync.WaitGroup
+}
+
+// New creates a download service from a validated configuration.
+func New(store blobstore.Store, jobStore jobs.Store, cfg *config.Config) (*Service, error) {
+	proxies, err := proxy.NewPool(cfg.Download.ProxyPool())
+	if err != nil {
+		return nil, err
+	}
+	return &Service{Store: store, Jobs: jobStore, Config: cfg, Proxies: proxies}, nil
+}
+
+// Handler is the HTTP entry point for video submissions. It records a queued job,
+// responds 202 Accepted with the job, and downloads the video in the background.
+// Wrap it in logging.Middleware so the job logs under the caller's correlation ID;
+// without one the job gets a new ID.
+func (s *Service) Handler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if logging.ID(ctx) == "" {
+		ctx = logging.WithID(ctx, logging.NewID())
+	}
+	// Request is validated in Meme Compiler API. Parse and use URL directly.
+	var submission Submission
+	err := json.NewDecoder(r.Body).Decode(&submission)
+	if err != nil {
+		writeErrorResponse(w, CodeBadRequest, "Request body must be a JSON submission.", http.StatusBadRequest)
+		slog.WarnContext(ctx, "Failed to decode request body", "error", err)
+		return
+	}
+	if submission.URL == "" {
+		writeErrorResponse(w, CodeBadRequest, "url is required.", http.StatusBadRequest)
+		return
+	}
+	clipRange, err := submission.Range()
+	if err != nil {
+		writeErrorResponse(w, CodeBadRequest, fmt.Sprintf("Invalid clip range: %v.", err), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	job := &jobs.Job{
+		ID:            jobs.NewID(),
+		State:         jobs.StateQueued,
+		URL:           submission.URL,
+		CorrelationID: logging.ID(ctx),
+		Created:       now,
+		Updated:       now,
+	}
+	if err := s.Jobs.Create(ctx, job); err != nil {
+		writeErrorResponse(w, CodeInternal, message(CodeInternal), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "Error creating job", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Accepted submission", "job", job.ID, "url", submission.URL, "submitter", submission.Submitter, "range", clipRange)
+
+	// The response is written from a copy because the download starts updating job straight away.
+	// The download outlives the request, so it keeps only the correlation ID from its context
+	// and gets JOB_TIMEOUT of its own.
+	accepted := *job
+	s.running.Add(1)
+	jobsRunning.Add(ctx, 1)
+	go func() {
+		ctx, cancel := deadline.WithTimeout(logging.WithID(context.Background(), job.CorrelationID), s.Config.Download.JobTimeoutDuration())
+		defer cancel()
+		defer s.running.Done()
+		defer jobsRunning.Add(ctx, -1)
+		s.process(ctx, job, submission, clipRange)
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+accepted.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&accepted)
+}
+
+// JobHandler serves GET /jobs/{id} with the job's current state.
+func (s *Service) JobHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, err := s.Jobs.Get(r.Context(), id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		writeErrorResponse(w, CodeNotFound, "No job with that ID.", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeErrorResponse(w, CodeInternal, message(CodeInternal), http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error reading job", "job", id, "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Wait blocks until every accepted job has finished.
+func (s *Service) Wait() {
+	s.running.Wait()
+}
+
+// setState records the job's progress. Failing to save it doesn't stop the download.
+func (s *Service) setState(ctx context.Context, job *jobs.Job, state jobs.State) {
+	job.State = state
+	job.Updated = time.Now()
+	if err := s.Jobs.Update(ctx, job); err != nil {
+		slog.ErrorContext(ctx, "Error updating job", "job", job.ID, "error", err)
+	}
+}
+
+// finish records the job's outcome from the webhook payload.
+func (s *Service) finish(ctx context.Context, job *jobs.Job, payload *WebhookPayload) {
+	job.Object = payload.Object
+	job.Bucket = payload.Bucket
+	job.Code = payload.Code
+	job.Error = payload.Error
+	job.Reason = payload.Reason
+	switch payload.Status {
+	case webhookStatusDuplicate:
+		s.setState(ctx, job, jobs.StateDuplicate)
+	case webhookStatusFailed:
+		s.setState(ctx, job, jobs.StateFailed)
+	default:
+		s.setState(ctx, job, jobs.StateDone)
+	}
+	slog.InfoContext(ctx, "Job finished", "job", job.ID, "state", job.State, "code", job.Code)
+	recordJob(ctx, string(job.State), job.Code, payload.Extractor, job.Created)
+}
+
+// process downloads the submission, moving the job through its states, and reports
+// the outcome to the submitter's webhook. yt-dlp and ffprobe are stopped CLEANUP_RESERVE
+// before ctx's deadline, which leaves time to upload the video.
+func (s *Service) process(ctx context.Context, job *jobs.Job, submission Submission, clipRange trim.Range) {
+	// Record the outcome and report it to the submitter's webhook on every exit path below
+	bucket := s.Config.Buckets.Quarantine
+	payload := &WebhookPayload{Status: webhookStatusCompleted, URL: submission.URL, Bucket: bucket}
+	ctx, span := telemetry.Start(ctx, tracer, "download", trace.WithAttributes(
+		attribute.String("job", job.ID),
+		attribute.String("url", submission.URL)))
+	defer func() {
+		// The outcome is recorded and sent even when the job ran out of time
+		cleanup, cancel := deadline.Cleanup(ctx, s.Config.CleanupReserveDuration())
+		defer cancel()
+		s.finish(cleanup, job, payload)
+		sendWebhook(cleanup, submission.Webhook, s.Config.Download.WebhookSecret.Value(), payload)
+		span.SetAttributes(attribute.String("state", string(job.State)), attribute.String("code", job.Code))
+		if job.State == jobs.StateFailed {
+			span.SetStatus(codes.Error, job.Code)
+		}
+		span.End()
+	}()
+
+	logger := slog.With("job", job.ID, "url", submission.URL)
+	options := s.ytdlpOptions()
+	work, cancelWork := deadline.Reserve(ctx, s.Config.CleanupReserveDuration())
+	defer cancelWork()
+
+	// Read the metadata first so live streams and videos over the limits are turned
+	// away before any of the video is transferred
+	s.setState(ctx, job, jobs.StateDownloading)
+	var info *ytdlp.Info
+	used, output, err := s.runYtdlp(work, "info", options, nil, func(options *ytdlp.Options) (string, error) {
+		var output string
+		var err error
+		info, output, err = options.FetchInfo(work, submission.URL)
+		return output, err
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "yt-dlp metadata error", "error", err, "output", output)
+		payload.fail(jobFailureCode(work, failureCode(ytdlp.Classify(output))), "")
+		return
+	}
+	payload.Extractor = info.Extractor
+	payload.Duration = info.Duration
+	limited := info
+	if !clipRange.IsZero() {
+		if err := clipRange.Check(info.Duration); err != nil {
+			logger.WarnContext(ctx, "Rejected clip range", "range", clipRange, "error", err)
+			payload.fail(CodeOutOfRange, "")
+			return
+		}
+		// Only the picked part is held to MAX_VIDEO_DURATION, so the meme in a long video
+		// can still be submitted
+		trimmed := *info
+		trimmed.Duration = clipRange.Length(info.Duration)
+		limited = &trimmed
+	}
+	if err := options.Limits.Check(limited); err != nil {
+		rejection, _ := ytdlp.AsRejection(err)
+		logger.WarnContext(ctx, "Rejected before download", "reason", rejection.Reason, "error", rejection)
+		payload.fail(rejection.Reason, rejection.Message)
+		return
+	}
+	if !clipRange.IsZero() {
+		// The download's --match-filter would hold the whole video to it
+		options.Limits.MaxDuration = 0
+	}
+
+	// Each job downloads into its own directory so concurrent jobs on the same
+	// instance never see each other's files, and nothing outlives the job
+	workDir, err := os.MkdirTemp("", "download-")
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating workspace", "error", err)
+		payload.fail(CodeInternal, "")
+		return
+	}
+	defer os.RemoveAll(workDir)
+
+	videoFileTemplate := filepath.Join(workDir, s.Config.Download.OutputTemplate)
+
+	// --print after_move:filepath writes the final path of the merged file to stdout.
+	// The download goes through the proxy that fetched the metadata while it stays healthy.
+	var stdout bytes.Buffer
+	_, output, err = s.runYtdlp(work, "download", options, used, func(options *ytdlp.Options) (string, error) {
+		cmd := options.Command(work, options.DownloadArgs(submission.URL, videoFileTemplate))
+		var stderr bytes.Buffer
+		stdout.Reset()
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		return stderr.String(), err
+	})
+	if err != nil {
+		logger.ErrorContext(ctx, "yt-dlp error", "error", err, "output", output)
+		payload.fail(jobFailureCode(work, failureCode(ytdlp.Classify(output))), "")
+		return
+	}
+	logger.DebugContext(ctx, "yt-dlp finished", "output", output)
+
+	// yt-dlp exits cleanly without a file when --max-filesize or --match-filter skip the video
+	videoFilePath, err := downloadedFile(stdout.String(), workDir)
+	if err != nil {
+		logger.WarnContext(ctx, "Error finding downloaded video file", "error", err, "output", output)
+		code := failureCode(ytdlp.Classify(output))
+		if code == CodeInternal {
+			// The metadata already passed the duration and live checks, so an unexplained
+			// skip is most likely --max-filesize on a video whose size wasn't known
+			code = CodeTooLarge
+		}
+		payload.fail(code, "")
+		return
+	}
+	logger.InfoContext(ctx, "Downloaded video", "file", videoFilePath, "extractor", info.Extractor)
+
+	payload.Object = filepath.Base(videoFilePath)
+	if stat, err := os.Stat(videoFilePath); err == nil {
+		payload.Size = stat.Size()
+		downloadedBytes.Add(ctx, payload.Size, metric.WithAttributes(attribute.String("extractor", info.Extractor)))
+	}
+
+	// Attribution travels with the object through normalize to the compilation credits
+	sourceURL := info.WebpageURL
+	if sourceURL == "" {
+		sourceURL = submission.URL
+	}
+	clip := metadata.Clip{
+		SourceURL: sourceURL,
+		Uploader:  info.Uploader,
+		Submitter: submission.Submitter,
+		Extractor: info.Extractor,
+	}
+
+	objectName := filepath.Base(videoFilePath)
+
+	// Reject files ffmpeg can't turn into a clip before they reach the quarantine bucket
+	result, err := probe.Run(work, videoFilePath)
+	if err == nil {
+		logger.InfoContext(ctx, "Probed video", "object", objectName, "probe", result.Summary())
+		err = probe.Inspect(result)
+	}
+	if rejection, ok := probe.AsRejection(err); ok {
+		logger.WarnContext(ctx, "Rejected video", "object", objectName, "reason", rejection.Reason, "error", rejection)
+		payload.fail(CodeRejected, rejection.Message)
+		payload.Reason = string(rejection.Reason)
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Error inspecting video file", "object", objectName, "error", err)
+		payload.fail(jobFailureCode(work, CodeInternal), "")
+		return
+	}
+
+	// Check if the video file already exists in the bucket
+	exists, err := blobstore.Exists(ctx, s.Store, bucket, objectName)
+	if err == nil && exists {
+		// Video file already exists in the bucket
+		logger.InfoContext(ctx, "Video file already exists in the bucket", "object", objectName)
+		payload.Status = webhookStatusDuplicate
+		return
+	}
+
+	// Open the downloaded video file
+	videoFile, err := os.Open(videoFilePath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error opening video file", "error", err)
+		payload.fail(CodeInternal, "")
+		return
+	}
+	defer videoFile.Close()
+
+	// Upload the video file to the store. The correlation ID rides along so normalize
+	// and concatenate log the clip under the same ID.
+	s.setState(ctx, job, jobs.StateUploading)
+	objectMetadata := clip.Metadata()
+	clipRange.Metadata(objectMetadata)
+	if job.CorrelationID != "" {
+		objectMetadata[metadata.KeyCorrelationID] = job.CorrelationID
+	}
+	opts := &blobstore.PutOptions{ContentType: "video/mp4", Metadata: objectMetadata}
+	if _, err := s.Store.Put(ctx, bucket, objectName, videoFile, opts); err != nil {
+		logger.ErrorContext(ctx, "Error uploading video", "bucket", bucket, "object", objectName, "error", err)
+		payload.fail(CodeInternal, "")
+		return
+	}
+
+	logger.InfoContext(ctx, "Video uploaded", "bucket", bucket, "object", objectName)
+}
+
+// ytdlpOptions builds the yt-dlp options from the configuration. The proxy is chosen per attempt.
+func (s *Service) ytdlpOptions() *ytdlp.Options {
+	download := s.Config.Download
+	return &ytdlp.Options{
+		Binary:            download.YtdlpPath,
+		Format:            download.Format,
+		CookiesFile:       download.CookiesFile,
+		CheckCertificates: download.CheckCertificates,
+		RateLimit:         download.RateLimit,
+		Limits: ytdlp.Limits{
+			MaxFilesize: download.MaxFilesize,
+			MaxDuration: download.MaxDuration,
+		},
+	}
+}
+
+// downloadedFile returns the path yt-dlp printed for the final file. Only the last line
+// is used, and it must be a regular file inside the request's workspace.
+func downloadedFile(stdout, workDir string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	path := strings.TrimSpace(lines[len(lines)-1])
+	if path == "" {
+		return "", fmt.Errorf("yt-dlp printed no file path")
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("filepath.Abs: %v", err)
+	}
+	if rel, err := filepath.Rel(workDir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("yt-dlp file %q is outside the workspace", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("os.Stat: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("yt-dlp path %q is not a file", path)
+	}
+	return path, nil
+}
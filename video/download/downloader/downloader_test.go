@@ -0,0 +1,204 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/jobs"
+)
+
+// fakeYtdlp prints info for --dump-json and otherwise "downloads" a file next to the
+// -o template, printing its path like --print after_move:filepath.
+const fakeYtdlp = `#!/bin/sh
+for arg; do
+	case "$prev" in -o) template=$arg;; esac
+	case "$arg" in --dump-json) info=1;; esac
+	prev=$arg
+done
+if [ -n "$info" ]; then
+	echo '{"id":"abc","extractor":"generic","uploader":"bob","webpage_url":"https://example.com/watch/abc","duration":30}'
+	exit 0
+fi
+out="$(dirname "$template")/generic-abc.mp4"
+head -c 1000 /dev/zero > "$out"
+echo "$out"
+`
+
+// newTestService returns a service on a local store that runs the fake yt-dlp and ffprobe.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	bin := testutil.Bin(t, map[string]string{"yt-dlp": fakeYtdlp, "ffprobe": testutil.FFprobe})
+	cfg := config.Default()
+	cfg.Download.YtdlpPath = filepath.Join(bin, "yt-dlp")
+	s, err := New(testutil.Store(t), jobs.NewMemory(time.Hour), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHandlerDownloadsIntoQuarantine(t *testing.T) {
+	s := newTestService(t)
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	rec := httptest.NewRecorder()
+	s.Handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"url": "https://example.com/v", "submitter": "alice", "start": "1", "end": "0:03.5"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusAccepted, rec.Body)
+	}
+	var accepted jobs.Job
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	if accepted.State != jobs.StateQueued {
+		t.Errorf("accepted job state = %s, want %s", accepted.State, jobs.StateQueued)
+	}
+	if location := rec.Header().Get("Location"); location != "/jobs/"+accepted.ID {
+		t.Errorf("Location = %q, want /jobs/%s", location, accepted.ID)
+	}
+	s.Wait()
+
+	// The finished job is served by JobHandler
+	job := getJob(t, s, accepted.ID)
+	if job.State != jobs.StateDone || job.Object != "generic-abc.mp4" {
+		t.Fatalf("job = %+v, want done with object generic-abc.mp4", job)
+	}
+
+	attrs, err := s.Store.Stat(context.Background(), s.Config.Buckets.Quarantine, job.Object)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	for key, want := range map[string]string{
+		metadata.KeySourceURL: "https://example.com/watch/abc",
+		metadata.KeyUploader:  "bob",
+		metadata.KeySubmitter: "alice",
+		metadata.KeyExtractor: "generic",
+		metadata.KeyTrimStart: "1.000",
+		metadata.KeyTrimEnd:   "3.500",
+	} {
+		if got := attrs.Metadata[key]; got != want {
+			t.Errorf("metadata %s = %q, want %q", key, got, want)
+		}
+	}
+
+	// The request's workspace is gone with everything yt-dlp wrote into it
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("workspace left behind: %v", entries)
+	}
+}
+
+func TestHandlerRejectsInvalidSubmissions(t *testing.T) {
+	s := newTestService(t)
+	for _, body := range []string{
+		`not json`,
+		`{"webhook": "https://example.com/hook"}`,
+		`{"url": "https://example.com/v", "start": "0:90"}`,
+		`{"url": "https://example.com/v", "start": "10", "end": "5"}`,
+	} {
+		rec := httptest.NewRecorder()
+		s.Handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.Code != CodeBadRequest {
+			t.Errorf("%s: response = %+v (%v), want code %s", body, resp, err, CodeBadRequest)
+		}
+	}
+}
+
+func TestHandlerFailsRangePastTheEnd(t *testing.T) {
+	s := newTestService(t)
+
+	// The fake video is 30 seconds long
+	rec := httptest.NewRecorder()
+	s.Handler(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"url": "https://example.com/v", "start": "45"}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusAccepted)
+	}
+	var accepted jobs.Job
+	if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil {
+		t.Fatal(err)
+	}
+	s.Wait()
+
+	if job := getJob(t, s, accepted.ID); job.State != jobs.StateFailed || job.Code != CodeOutOfRange {
+		t.Errorf("job = %+v, want failed with code %s", job, CodeOutOfRange)
+	}
+}
+
+func TestJobHandlerUnknownJob(t *testing.T) {
+	s := newTestService(t)
+	for _, id := range []string{jobs.NewID(), "not-a-job"} {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+		req.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		s.JobHandler(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", id, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+// getJob returns the job JobHandler serves for id.
+func getJob(t *testing.T, s *Service, id string) *jobs.Job {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil)
+	req.SetPathValue("id", id)
+	rec := httptest.NewRecorder()
+	s.JobHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /jobs/%s status = %d, want %d", id, rec.Code, http.StatusOK)
+	}
+	var job jobs.Job
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	return &job
+}
+
+func TestDownloadedFile(t *testing.T) {
+	workDir := t.TempDir()
+	video := filepath.Join(workDir, "generic-abc.mp4")
+	if err := os.WriteFile(video, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "generic-abc.mp4")
+	if err := os.WriteFile(outside, []byte("video"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		stdout  string
+		want    string
+		wantErr bool
+	}{
+		{"path", video + "\n", video, false},
+		{"last line", "[download] 100%\n" + video + "\n", video, false},
+		{"nothing printed", "", "", true},
+		{"outside the workspace", outside + "\n", "", true},
+		{"directory", workDir + "\n", "", true},
+		{"missing", filepath.Join(workDir, "missing.mp4") + "\n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := downloadedFile(tt.stdout, workDir)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("downloadedFile = %q, %v, want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
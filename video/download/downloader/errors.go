@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/ytdlp"
+)
+
+// Error codes set on failed jobs and webhooks, and on HTTP error responses. The
+// messages that go with them are written for the person who submitted the video and
+// never include yt-dlp's output, which is only logged.
+const (
+	CodeBadRequest     = "bad_request"
+	CodeNotFound       = "not_found"
+	CodeUnsupportedURL = "unsupported_url"
+	CodePrivateVideo   = "private_video"
+	CodeUnavailable    = "unavailable"
+	CodeGeoBlocked     = "geo_blocked"
+	CodeTooLarge       = "too_large"
+	CodeTooLong        = "too_long"
+	CodeLiveStream     = "live_stream"
+	// CodeOutOfRange is a clip range that starts past the end of the video.
+	CodeOutOfRange = "out_of_range"
+	// CodeRejected is a file that failed inspection. The reason field says why.
+	CodeRejected = "rejected"
+	CodeNetwork  = "network"
+	// CodeTimeout is a job that ran out of JOB_TIMEOUT.
+	CodeTimeout  = "timeout"
+	CodeInternal = "internal"
+)
+
+var codeMessages = map[string]string{
+	CodeUnsupportedURL: "This link isn't from a supported site.",
+	CodePrivateVideo:   "This video is private or needs a login.",
+	CodeUnavailable:    "This video is unavailable or has been removed.",
+	CodeGeoBlocked:     "This video isn't available in our region.",
+	CodeTooLarge:       "This video is too large.",
+	CodeTooLong:        "This video is too long.",
+	CodeLiveStream:     "Live streams can't be downloaded.",
+	CodeOutOfRange:     "The start time is past the end of this video.",
+	CodeRejected:       "This file can't be used in a compilation.",
+	CodeNetwork:        "The video site couldn't be reached. Try again later.",
+	CodeTimeout:        "This video took too long to download.",
+	CodeInternal:       "Something went wrong downloading this video.",
+}
+
+// message returns the caller-facing message for code.
+func message(code string) string {
+	if m, ok := codeMessages[code]; ok {
+		return m
+	}
+	return codeMessages[CodeInternal]
+}
+
+// failureCode maps a classified yt-dlp failure onto an error code.
+func failureCode(failure ytdlp.Failure) string {
+	switch failure {
+	case ytdlp.FailureUnsupportedURL:
+		return CodeUnsupportedURL
+	case ytdlp.FailurePrivate:
+		return CodePrivateVideo
+	case ytdlp.FailureUnavailable:
+		return CodeUnavailable
+	case ytdlp.FailureGeoBlocked:
+		return CodeGeoBlocked
+	case ytdlp.FailureTooLarge:
+		return CodeTooLarge
+	case ytdlp.FailureFiltered:
+		// The filter only rejects live streams and videos over the duration limit,
+		// and live streams are almost always caught before the download
+		return CodeTooLong
+	case ytdlp.FailureNetwork:
+		return CodeNetwork
+	default:
+		return CodeInternal
+	}
+}
+
+// jobFailureCode returns CodeTimeout when the job's work failed because it ran out of
+// time, and code otherwise.
+func jobFailureCode(work context.Context, code string) string {
+	if errors.Is(work.Err(), context.DeadlineExceeded) {
+		return CodeTimeout
+	}
+	return code
+}
+
+// ErrorResponse is the body of every HTTP error from the download service.
+type ErrorResponse struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+func writeErrorResponse(w http.ResponseWriter, code, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Error: message})
+}
@@ -0,0 +1,60 @@
+package downloader
+
+import (
+	"context"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/DC00/meme-compiler-cloud-functions/video/download/downloader"
+
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	jobsFinished = telemetry.Must(meter.Int64Counter("download.jobs",
+		metric.WithDescription("Finished download jobs by state, error code and extractor.")))
+	jobsRunning = telemetry.Must(meter.Int64UpDownCounter("download.jobs.running",
+		metric.WithDescription("Download jobs accepted but not finished on this instance.")))
+	jobDuration = telemetry.Must(meter.Float64Histogram("download.job.duration",
+		metric.WithDescription("Time from accepting a submission to finishing its job."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+	ytdlpDuration = telemetry.Must(meter.Float64Histogram("download.ytdlp.duration",
+		metric.WithDescription("yt-dlp runtime by step (info or download) and outcome."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+	downloadedBytes = telemetry.Must(meter.Int64Counter("download.bytes",
+		metric.WithDescription("Size of the downloaded videos by extractor."),
+		metric.WithUnit("By")))
+	proxyFailovers = telemetry.Must(meter.Int64Counter("download.proxy.failovers",
+		metric.WithDescription("yt-dlp attempts that failed through a proxy and moved on to the next one.")))
+)
+
+// recordJob records a finished job's outcome and duration.
+func recordJob(ctx context.Context, state, code, extractor string, created time.Time) {
+	if extractor == "" {
+		extractor = "unknown"
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("state", state),
+		attribute.String("code", code),
+		attribute.String("extractor", extractor))
+	jobsFinished.Add(ctx, 1, attrs)
+	jobDuration.Record(ctx, telemetry.Seconds(created), attrs)
+}
+
+// recordYtdlp records how long a yt-dlp step took.
+func recordYtdlp(ctx context.Context, step string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	ytdlpDuration.Record(ctx, telemetry.Seconds(start), metric.WithAttributes(
+		attribute.String("step", step),
+		attribute.String("outcome", outcome)))
+}
@@ -0,0 +1,65 @@
+package downloader
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/proxy"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/ytdlp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// runYtdlp is viaProxies in a span, recording the step's duration across every proxy tried.
+func (s *Service) runYtdlp(ctx context.Context, step string, options *ytdlp.Options, prefer *proxy.Proxy, attempt func(*ytdlp.Options) (string, error)) (*proxy.Proxy, string, error) {
+	ctx, span := telemetry.Start(ctx, tracer, "yt-dlp "+step)
+	start := time.Now()
+	used, output, err := s.viaProxies(ctx, options, prefer, attempt)
+	recordYtdlp(ctx, step, start, err)
+	telemetry.End(span, err)
+	return used, output, err
+}
+
+// viaProxies runs attempt through each proxy in the pool, starting with prefer when it is
+// healthy, until one succeeds or yt-dlp fails for a reason another proxy wouldn't fix.
+// Without proxies attempt runs once with a direct connection. It returns the proxy used,
+// and attempt's output with the proxy credentials removed.
+func (s *Service) viaProxies(ctx context.Context, options *ytdlp.Options, prefer *proxy.Proxy, attempt func(*ytdlp.Options) (string, error)) (*proxy.Proxy, string, error) {
+	candidates := s.Proxies.Order(prefer)
+	if len(candidates) == 0 {
+		output, err := attempt(options)
+		return nil, output, err
+	}
+
+	var output string
+	var err error
+	for i, p := range candidates {
+		withProxy := *options
+		withProxy.Proxy = p.URL()
+		output, err = attempt(&withProxy)
+		output = p.Redact(output)
+		if err == nil {
+			s.Proxies.Succeeded(p)
+			return p, output, nil
+		}
+		// A run killed for running out of time says nothing about the proxy
+		if !ytdlp.Retryable(output) || ctx.Err() != nil {
+			return p, output, err
+		}
+
+		cooldown := s.Proxies.Failed(p)
+		proxyFailovers.Add(ctx, 1, metric.WithAttributes(attribute.String("proxy", p.String())))
+		slog.WarnContext(ctx, "yt-dlp failed through proxy, resting it",
+			"proxy", p.String(), "attempt", i+1, "proxies", len(candidates), "cooldown", cooldown, "output", lastLine(output))
+	}
+	return nil, output, err
+}
+
+// lastLine returns the last non-empty line of yt-dlp's output, which is usually the error.
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,146 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+)
+
+const (
+	webhookStatusCompleted = "completed"
+	webhookStatusDuplicate = "duplicate"
+	webhookStatusFailed    = "failed"
+
+	webhookMaxAttempts    = 5
+	webhookInitialBackoff = 500 * time.Millisecond
+	webhookMaxBackoff     = 8 * time.Second
+	webhookTimeout        = 10 * time.Second
+
+	// Receivers verify the signature by computing
+	// hex(HMAC-SHA256(secret, timestamp + "." + body)).
+	webhookSignatureHeader = "X-Signature-SHA256"
+	webhookTimestampHeader = "X-Signature-Timestamp"
+)
+
+// WebhookPayload is POSTed to Submission.Webhook once the download finishes.
+type WebhookPayload struct {
+	Status    string  `json:"status"`
+	URL       string  `json:"url"`
+	Object    string  `json:"object,omitempty"`
+	Bucket    string  `json:"bucket,omitempty"`
+	Extractor string  `json:"extractor,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Size      int64   `json:"size,omitempty"`
+	// Code and Error are set when the download failed. Error is safe to show to the submitter.
+	Code  string `json:"code,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Reason is the probe rejection reason when Code is rejected.
+	Reason string `json:"reason,omitempty"`
+}
+
+// fail marks the payload as failed with an error code. The message defaults to the
+// code's message and must never contain yt-dlp output.
+func (p *WebhookPayload) fail(code, detail string) {
+	p.Status = webhookStatusFailed
+	p.Code = code
+	p.Error = detail
+	if detail == "" {
+		p.Error = message(code)
+	}
+}
+
+// webhookClient passes the job's correlation ID on to the receiver.
+var webhookClient = &http.Client{Timeout: webhookTimeout, Transport: &logging.Transport{}}
+
+// sendWebhook delivers the payload to the webhook URL, retrying with
+// exponential backoff on network errors, 429s and 5xx responses.
+// It runs at the end of the job, after the final state has been recorded.
+func sendWebhook(ctx context.Context, webhookURL, secret string, payload *WebhookPayload) {
+	if webhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook payload", "error", err)
+		return
+	}
+
+	if secret == "" {
+		slog.WarnContext(ctx, "WEBHOOK_SECRET is not set, sending unsigned webhook")
+	}
+
+	backoff := webhookInitialBackoff
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		retry, err := postWebhook(ctx, webhookURL, body, secret)
+		if err == nil {
+			slog.InfoContext(ctx, "Webhook delivered", "webhook", webhookURL, "status", payload.Status)
+			return
+		}
+		slog.WarnContext(ctx, "Webhook attempt failed", "webhook", webhookURL, "attempt", attempt, "attempts", webhookMaxAttempts, "error", err)
+		if !retry || attempt == webhookMaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			slog.ErrorContext(ctx, "Giving up on webhook delivery, out of time", "webhook", webhookURL)
+			return
+		}
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+	slog.ErrorContext(ctx, "Giving up on webhook delivery", "webhook", webhookURL)
+}
+
+// postWebhook makes a single delivery attempt and reports whether a failure is worth retrying.
+func postWebhook(ctx context.Context, webhookURL string, body []byte, secret string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("http.NewRequestWithContext: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(webhookTimestampHeader, timestamp)
+		req.Header.Set(webhookSignatureHeader, signWebhook(secret, timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("webhookClient.Do: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of the timestamp and body.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
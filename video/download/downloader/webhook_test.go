@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// webhookReceiver records the requests sent to it and answers each with the next status.
+func webhookReceiver(t *testing.T, statuses ...int) (*httptest.Server, *[]*http.Request, *[][]byte) {
+	t.Helper()
+	var requests []*http.Request
+	var bodies [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+		if n := len(requests); n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests, &bodies
+}
+
+func TestSendWebhookSignsPayload(t *testing.T) {
+	server, requests, bodies := webhookReceiver(t)
+
+	sendWebhook(context.Background(), server.URL, "secret", &WebhookPayload{Status: webhookStatusCompleted, URL: "https://example.com/v", Object: "generic-abc.mp4"})
+	if len(*requests) != 1 {
+		t.Fatalf("webhook received %d requests, want 1", len(*requests))
+	}
+
+	var payload WebhookPayload
+	if err := json.Unmarshal((*bodies)[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Status != webhookStatusCompleted || payload.Object != "generic-abc.mp4" {
+		t.Errorf("payload = %+v, want completed with object generic-abc.mp4", payload)
+	}
+
+	// Receivers check hex(HMAC-SHA256(secret, timestamp + "." + body))
+	header := (*requests)[0].Header
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(header.Get(webhookTimestampHeader) + "."))
+	mac.Write((*bodies)[0])
+	if got, want := header.Get(webhookSignatureHeader), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+}
+
+func TestSendWebhookUnsigned(t *testing.T) {
+	server, requests, _ := webhookReceiver(t)
+
+	sendWebhook(context.Background(), server.URL, "", &WebhookPayload{Status: webhookStatusFailed})
+	if len(*requests) != 1 {
+		t.Fatalf("webhook received %d requests, want 1", len(*requests))
+	}
+	if header := (*requests)[0].Header; header.Get(webhookSignatureHeader) != "" || header.Get(webhookTimestampHeader) != "" {
+		t.Errorf("unsigned webhook has signature headers: %v", header)
+	}
+}
+
+func TestSendWebhookRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+	}{
+		{"server error", []int{http.StatusServiceUnavailable, http.StatusOK}, 2},
+		{"rate limited", []int{http.StatusTooManyRequests, http.StatusOK}, 2},
+		{"client error", []int{http.StatusBadRequest}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests, _ := webhookReceiver(t, tt.statuses...)
+			sendWebhook(context.Background(), server.URL, "secret", &WebhookPayload{Status: webhookStatusCompleted})
+			if len(*requests) != tt.attempts {
+				t.Errorf("webhook received %d requests, want %d", len(*requests), tt.attempts)
+			}
+		})
+	}
+}
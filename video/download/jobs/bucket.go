@@ -0,0 +1,91 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+)
+
+// Bucket is a Store that keeps each job as a JSON object in a bucket, so every
+// instance of the service sees every job. Old jobs are left to the bucket's
+// lifecycle rules.
+type Bucket struct {
+	store  blobstore.Store
+	bucket string
+}
+
+// NewBucket creates a Store in bucket.
+func NewBucket(store blobstore.Store, bucket string) *Bucket {
+	return &Bucket{store: store, bucket: bucket}
+}
+
+func objectName(id string) string {
+	return id + ".json"
+}
+
+func (b *Bucket) Create(ctx context.Context, job *Job) error {
+	if !ValidID(job.ID) {
+		return fmt.Errorf("jobs: invalid job ID %q", job.ID)
+	}
+	err := b.write(ctx, job, &blobstore.PutOptions{IfNotExists: true})
+	if errors.Is(err, blobstore.ErrPreconditionFailed) {
+		return fmt.Errorf("jobs: job %s already exists", job.ID)
+	}
+	return err
+}
+
+func (b *Bucket) Get(ctx context.Context, id string) (*Job, error) {
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	r, err := b.store.Get(ctx, b.bucket, objectName(id))
+	if errors.Is(err, blobstore.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("store.Get: %v", err)
+	}
+	defer r.Close()
+
+	var job Job
+	if err := json.NewDecoder(r).Decode(&job); err != nil {
+		return nil, fmt.Errorf("json.Decode: %v", err)
+	}
+	return &job, nil
+}
+
+func (b *Bucket) Update(ctx context.Context, job *Job) error {
+	if !ValidID(job.ID) {
+		return ErrNotFound
+	}
+	attrs, err := b.store.Stat(ctx, b.bucket, objectName(job.ID))
+	if errors.Is(err, blobstore.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("store.Stat: %v", err)
+	}
+	// Only the instance running the job updates it, so the generation only guards
+	// against the object being deleted in between
+	err = b.write(ctx, job, &blobstore.PutOptions{IfGenerationMatch: attrs.Generation})
+	if errors.Is(err, blobstore.ErrPreconditionFailed) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (b *Bucket) write(ctx context.Context, job *Job, opts *blobstore.PutOptions) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	opts.ContentType = "application/json"
+	if _, err := b.store.Put(ctx, b.bucket, objectName(job.ID), bytes.NewReader(data), opts); err != nil {
+		return fmt.Errorf("store.Put: %w", err)
+	}
+	return nil
+}
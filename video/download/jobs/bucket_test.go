@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+)
+
+func TestBucket(t *testing.T) {
+	ctx := context.Background()
+	store := testutil.Store(t)
+	// Two instances sharing the bucket
+	first, second := NewBucket(store, "jobs"), NewBucket(store, "jobs")
+
+	job := &Job{ID: NewID(), State: StateQueued, URL: "https://example.com/v"}
+	if err := first.Create(ctx, job); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := second.Create(ctx, job); err == nil {
+		t.Error("Create of an existing job succeeded")
+	}
+
+	job.State, job.Object = StateDone, "youtube-abc.mp4"
+	if err := first.Update(ctx, job); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, err := second.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != StateDone || got.Object != job.Object {
+		t.Errorf("Get = %+v, want the updated job", got)
+	}
+
+	for _, id := range []string{NewID(), "../" + job.ID, ""} {
+		if _, err := second.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+	if err := first.Update(ctx, &Job{ID: NewID()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of an unknown job = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// File is a Store that keeps each job as a JSON file in a directory.
+type File struct {
+	dir string
+}
+
+// NewFile creates a Store in dir, creating the directory if needed.
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %v", err)
+	}
+	return &File{dir: dir}, nil
+}
+
+func (f *File) path(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *File) Create(ctx context.Context, job *Job) error {
+	if !ValidID(job.ID) {
+		return fmt.Errorf("jobs: invalid job ID %q", job.ID)
+	}
+	if _, err := os.Stat(f.path(job.ID)); err == nil {
+		return fmt.Errorf("jobs: job %s already exists", job.ID)
+	}
+	return f.write(job)
+}
+
+func (f *File) Get(ctx context.Context, id string) (*Job, error) {
+	// IDs become file names, so anything else can't name a job
+	if !ValidID(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(f.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadFile: %v", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	return &job, nil
+}
+
+func (f *File) Update(ctx context.Context, job *Job) error {
+	if !ValidID(job.ID) {
+		return ErrNotFound
+	}
+	if _, err := os.Stat(f.path(job.ID)); errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return f.write(job)
+}
+
+// write replaces the job's file through a rename so readers never see a partial file.
+func (f *File) write(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(f.dir, ".job-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("tmp.Write: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("tmp.Close: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path(job.ID)); err != nil {
+		return fmt.Errorf("os.Rename: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "jobs")
+	store, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := &Job{ID: NewID(), State: StateQueued, URL: "https://example.com/v"}
+	if err := store.Create(ctx, job); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := store.Create(ctx, job); err == nil {
+		t.Error("Create of an existing job succeeded")
+	}
+	if err := store.Create(ctx, &Job{ID: "../escape"}); err == nil {
+		t.Error("Create with an invalid ID succeeded")
+	}
+
+	job.State, job.Object = StateDone, "youtube-abc.mp4"
+	if err := store.Update(ctx, job); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// Jobs outlive the process that wrote them
+	reopened, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != StateDone || got.Object != job.Object {
+		t.Errorf("Get = %+v, want the updated job", got)
+	}
+
+	for _, id := range []string{NewID(), "../" + job.ID, ""} {
+		if _, err := reopened.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound", id, err)
+		}
+	}
+	if err := store.Update(ctx, &Job{ID: NewID()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of an unknown job = %v, want ErrNotFound", err)
+	}
+
+	// Writes go through a temporary file that is renamed over the job
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != job.ID+".json" {
+		t.Errorf("job directory has %v, want only %s.json", entries, job.ID)
+	}
+}
@@ -0,0 +1,80 @@
+// Package jobs tracks the state of download jobs so a submission can return as soon
+// as it is accepted and be polled afterwards. The in-memory store is enough for a
+// single instance. The bucket store shares state between instances, and the file
+// store keeps it on a shared or persistent volume.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned when no job has the requested ID.
+var ErrNotFound = errors.New("jobs: job not found")
+
+// State is where a job is in the download. Done, Duplicate and Failed are final.
+type State string
+
+const (
+	StateQueued      State = "queued"
+	StateDownloading State = "downloading"
+	StateUploading   State = "uploading"
+	StateDone        State = "done"
+	StateDuplicate   State = "duplicate"
+	StateFailed      State = "failed"
+)
+
+// Final reports whether the job has finished.
+func (s State) Final() bool {
+	return s == StateDone || s == StateDuplicate || s == StateFailed
+}
+
+// Job is a single submission and its outcome.
+type Job struct {
+	ID    string `json:"id"`
+	State State  `json:"state"`
+	URL   string `json:"url"`
+	// CorrelationID is the logging correlation ID of the submission, for searching the logs.
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Object and Bucket are set once the video has been downloaded.
+	Object string `json:"object,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	// Code, Error and Reason are set when State is failed. Code is machine-readable,
+	// Error is a message for the submitter and Reason is a probe rejection reason.
+	Code    string    `json:"code,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Store persists jobs. Implementations store copies, so callers may keep modifying
+// a job after saving it.
+type Store interface {
+	// Create saves a new job.
+	Create(ctx context.Context, job *Job) error
+	// Get returns the job with the ID, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Job, error)
+	// Update replaces a job saved by Create.
+	Update(ctx context.Context, job *Job) error
+}
+
+var idPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// NewID returns a random job ID.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidID reports whether id has the form NewID returns.
+func ValidID(id string) bool {
+	return idPattern.MatchString(id)
+}
@@ -0,0 +1,98 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxFinished caps how many finished jobs Memory keeps, however recent, so a burst of
+// submissions can't grow the instance's memory without bound.
+const MaxFinished = 10000
+
+// evictInterval is how often Memory looks for expired jobs while under MaxFinished.
+const evictInterval = time.Minute
+
+// Memory is a Store held in process memory. Jobs are lost when the instance exits,
+// and finished jobs are dropped once they are older than the retention or there are
+// more than MaxFinished of them. Running jobs are always kept.
+type Memory struct {
+	mu        sync.Mutex
+	jobs      map[string]Job
+	retention time.Duration
+	lastEvict time.Time
+	now       func() time.Time
+}
+
+// NewMemory creates an empty in-memory store that keeps finished jobs for retention.
+func NewMemory(retention time.Duration) *Memory {
+	return &Memory{jobs: make(map[string]Job), retention: retention, now: time.Now}
+}
+
+func (m *Memory) Create(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; ok {
+		return fmt.Errorf("jobs: job %s already exists", job.ID)
+	}
+	m.evict()
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (m *Memory) Update(ctx context.Context, job *Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[job.ID]; !ok {
+		return ErrNotFound
+	}
+	m.jobs[job.ID] = *job
+	return nil
+}
+
+// evict drops finished jobs past the retention, then the oldest finished jobs over
+// MaxFinished. It runs from Create, which is as often as the store can grow, but
+// only scans the jobs once per evictInterval unless the store is over the cap.
+func (m *Memory) evict() {
+	now := m.now()
+	if len(m.jobs) < MaxFinished && now.Sub(m.lastEvict) < evictInterval {
+		return
+	}
+	m.lastEvict = now
+
+	cutoff := now.Add(-m.retention)
+	var finished []Job
+	for id, job := range m.jobs {
+		if !job.State.Final() {
+			continue
+		}
+		if job.Updated.Before(cutoff) {
+			delete(m.jobs, id)
+			continue
+		}
+		finished = append(finished, job)
+	}
+
+	// Drop a tenth more than needed so the next Create doesn't have to sort again
+	if extra := len(finished) - MaxFinished; extra >= 0 {
+		extra += MaxFinished / 10
+		sort.Slice(finished, func(i, j int) bool {
+			return finished[i].Updated.Before(finished[j].Updated)
+		})
+		for _, job := range finished[:extra] {
+			delete(m.jobs, job.ID)
+		}
+	}
+}
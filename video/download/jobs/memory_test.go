@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(time.Hour)
+
+	job := &Job{ID: NewID(), State: StateQueued, URL: "https://example.com/v"}
+	if err := m.Create(ctx, job); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := m.Create(ctx, job); err == nil {
+		t.Error("Create of an existing job succeeded")
+	}
+
+	// The store keeps a copy, not the caller's job
+	job.State = StateDownloading
+	got, err := m.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.State != StateQueued {
+		t.Errorf("State = %s before Update, want %s", got.State, StateQueued)
+	}
+
+	if err := m.Update(ctx, job); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if got, _ := m.Get(ctx, job.ID); got.State != StateDownloading {
+		t.Errorf("State = %s after Update, want %s", got.State, StateDownloading)
+	}
+
+	if _, err := m.Get(ctx, NewID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of an unknown job = %v, want ErrNotFound", err)
+	}
+	if err := m.Update(ctx, &Job{ID: NewID()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update of an unknown job = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryEvictsExpiredFinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Hour)
+	m.now = func() time.Time { return now }
+
+	old := now.Add(-2 * time.Hour)
+	expired := &Job{ID: NewID(), State: StateDone, Updated: old}
+	running := &Job{ID: NewID(), State: StateDownloading, Updated: old}
+	recent := &Job{ID: NewID(), State: StateFailed, Updated: now.Add(-time.Minute)}
+	for _, job := range []*Job{expired, running, recent} {
+		if err := m.Create(ctx, job); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	// Eviction happens on a Create once evictInterval has passed
+	now = now.Add(evictInterval)
+	if err := m.Create(ctx, &Job{ID: NewID(), State: StateQueued, Updated: now}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := m.Get(ctx, expired.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired finished job was kept, Get = %v", err)
+	}
+	for _, job := range []*Job{running, recent} {
+		if _, err := m.Get(ctx, job.ID); err != nil {
+			t.Errorf("job in state %s was evicted: %v", job.State, err)
+		}
+	}
+}
+
+func TestMemoryCapsFinishedJobs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := NewMemory(24 * time.Hour)
+	m.now = func() time.Time { return now }
+
+	var oldest string
+	for i := 0; i < MaxFinished+10; i++ {
+		job := &Job{ID: NewID(), State: StateDone, Updated: now.Add(time.Duration(i) * time.Second)}
+		if i == 0 {
+			oldest = job.ID
+		}
+		if err := m.Create(ctx, job); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	if n := len(m.jobs); n > MaxFinished {
+		t.Errorf("store holds %d jobs, want at most %d", n, MaxFinished)
+	}
+	if _, err := m.Get(ctx, oldest); !errors.Is(err, ErrNotFound) {
+		t.Errorf("oldest finished job was kept, Get = %v", err)
+	}
+}
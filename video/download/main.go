@@ -2,167 +2,100 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"os/exec"
-	"path/filepath"
-
-	"cloud.google.com/go/storage"
-)
-
-const (
-	bucketName = "videos-quarantine-2486aa1dcdb442fda0c2f090761b4479"
+	"os/signal"
+	"syscall"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/downloader"
+	"github.com/DC00/meme-compiler-cloud-functions/video/download/jobs"
 )
 
-type Submission struct {
-	URL     string `json:"url"`
-	Webhook string `json:"webhook"`
-}
-
 func main() {
-	log.Print("Starting server...")
-	http.HandleFunc("/", handler)
-
-	// Determine port for HTTP service.
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-		log.Printf("Defaulting to port %s", port)
-	}
-
-	// Start HTTP server.
-	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatal(err)
-	}
-}
-
-func handler(w http.ResponseWriter, r *http.Request) {
-	log.Print("Handler invoked")
-	// Request is validated in Meme Compiler API. Parse and use URL directly.
-	var submission Submission
-	err := json.NewDecoder(r.Body).Decode(&submission)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Printf("Failed to decode request body: %v", err)
-		return
-	}
-	log.Printf("Received submission: %+v", submission)
-
-	// Set the path to the "yt-dlp" binary
-	ytdlpPath := "/usr/local/bin/yt-dlp"
-	log.Printf("Using yt-dlp binary at: %s", ytdlpPath)
-
-	// Set the additional flags for "yt-dlp"
-	format := "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4]"
-	outputTemplate := "%(extractor)s-%(id)s.%(ext)s"
-	targetDir := "/tmp"
-	videoFileTemplate := filepath.Join(targetDir, outputTemplate)
-	log.Printf("yt-dlp output template: %s", videoFileTemplate)
-
-	// Fetch proxy credentials from environment variables
-	proxyUser := os.Getenv("PROXY_USER")
-	proxyPassword := os.Getenv("PROXY_PASSWORD")
-	proxyURL := os.Getenv("PROXY_URL")
+	logging.Setup("download")
+	slog.Info("Starting server...")
 
-	if proxyUser == "" || proxyPassword == "" || proxyURL == "" {
-		http.Error(w, "Proxy credentials or URL are not set", http.StatusInternalServerError)
-		log.Print("Proxy credentials or URL are not set")
-		return
-	}
-
-	proxy := fmt.Sprintf("http://%s:%s@%s", proxyUser, proxyPassword, proxyURL)
-
-	// Create the "yt-dlp" command with the specified flags
-	cmd := exec.Command(ytdlpPath, "--proxy", proxy, "--format", format, "-o", videoFileTemplate, "--restrict-filenames", "--no-check-certificates", submission.URL)
-
-	// Execute the "yt-dlp" command to download the video
-	output, err := cmd.CombinedOutput()
+	// Load and validate configuration before accepting any requests
+	cfg, err := config.Load(config.ServiceDownload)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error downloading video: %s", string(output)), http.StatusInternalServerError)
-		log.Printf("yt-dlp error: %s", string(output))
-		return
+		logging.Fatal("Invalid configuration", "error", err)
 	}
-	log.Printf("yt-dlp output: %s", string(output))
+	slog.Info("Effective configuration", "config", cfg)
 
-	// Find the downloaded MP4 file in the "/tmp" directory
-	files, err := filepath.Glob("/tmp/*.mp4")
+	shutdownTelemetry, err := telemetry.Setup(context.Background(), "download")
 	if err != nil {
-		http.Error(w, "Failed to search for downloaded video file", http.StatusInternalServerError)
-		log.Printf("Error searching for downloaded video file: %v", err)
-		return
-	}
-
-	if len(files) == 0 {
-		http.Error(w, "No downloaded video file found", http.StatusInternalServerError)
-		log.Print("No downloaded video file found")
-		return
+		logging.Fatal("Error setting up telemetry", "error", err)
 	}
 
-	videoFilePath := files[0]
-	log.Printf("Downloaded video file found: %s", videoFilePath)
-
 	// Create a new Cloud Storage client
-	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+	gcs, err := blobstore.NewGCS(context.Background())
 	if err != nil {
-		http.Error(w, "Failed to create Cloud Storage client", http.StatusInternalServerError)
-		log.Printf("Error creating Cloud Storage client: %v", err)
-		return
+		logging.Fatal("Error creating Cloud Storage client", "error", err)
 	}
-	defer client.Close()
-
-	// Create a new object handle in the bucket
-	obj := client.Bucket(bucketName).Object(filepath.Base(videoFilePath))
-	log.Printf("Created Cloud Storage object handle for: %s", obj.ObjectName())
-
-	// Check if the video file already exists in the bucket
-	exists, err := obj.Attrs(ctx)
-	if err == nil && exists != nil {
-		// Video file already exists in the bucket
-		log.Printf("Video file already exists in the bucket: %s", obj.ObjectName())
-
-		// Delete the temporary video file from the container
-		err = os.Remove(videoFilePath)
+	defer gcs.Close()
+	store := blobstore.Instrument(gcs)
+
+	// Job state is per instance unless JOBS_BUCKET or JOBS_DIR is shared by every instance
+	var jobStore jobs.Store
+	switch {
+	case cfg.Download.JobsBucket != "":
+		jobStore = jobs.NewBucket(store, cfg.Download.JobsBucket)
+		slog.Info("Storing jobs in a bucket", "bucket", cfg.Download.JobsBucket)
+	case cfg.Download.JobsDir != "":
+		jobStore, err = jobs.NewFile(cfg.Download.JobsDir)
 		if err != nil {
-			log.Printf("Failed to delete temporary video file: %s", err)
+			logging.Fatal("Error creating job store", "error", err)
 		}
-
-		return
+		slog.Info("Storing jobs on disk", "dir", cfg.Download.JobsDir)
+	default:
+		jobStore = jobs.NewMemory(cfg.Download.JobRetentionDuration())
+		slog.Warn("Storing jobs in memory, deploy with --max-instances=1 or set JOBS_BUCKET so jobs can be polled from any instance")
 	}
 
-	// Open the downloaded video file
-	videoFile, err := os.Open(videoFilePath)
+	service, err := downloader.New(store, jobStore, cfg)
 	if err != nil {
-		http.Error(w, "Failed to open video file", http.StatusInternalServerError)
-		log.Printf("Error opening video file: %v", err)
-		return
+		logging.Fatal("Error creating download service", "error", err)
 	}
-	defer videoFile.Close()
-
-	// Upload the video file to Cloud Storage
-	writer := obj.NewWriter(ctx)
-	if _, err := io.Copy(writer, videoFile); err != nil {
-		http.Error(w, "Failed to upload video to Cloud Storage", http.StatusInternalServerError)
-		log.Printf("Error uploading video to Cloud Storage: %v", err)
-		return
+	if service.Proxies.Len() == 0 {
+		slog.Info("No proxy configured, downloading directly")
 	}
-	if err := writer.Close(); err != nil {
-		http.Error(w, "Failed to close Cloud Storage writer", http.StatusInternalServerError)
-		log.Printf("Error closing Cloud Storage writer: %v", err)
-		return
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /", service.Handler)
+	mux.HandleFunc("GET /jobs/{id}", service.JobHandler)
+	if cfg.DebugEndpoints {
+		mux.HandleFunc("GET /debug/config", config.Handler(cfg))
 	}
 
-	// Delete the temporary video file from the container
-	err = os.Remove(videoFilePath)
-	if err != nil {
-		log.Printf("Failed to delete temporary video file: %s", err)
+	// Determine port for HTTP service.
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+		slog.Info("Defaulting to port", "port", port)
 	}
 
-	// Send a response back to the client
-	log.Printf("Video downloaded and uploaded to Cloud Storage bucket: %s", bucketName)
+	// Cloud Run sends SIGTERM before stopping the instance. Stop accepting requests
+	// and let the jobs already running finish.
+	server := &http.Server{Addr: ":" + port, Handler: logging.Middleware(mux)}
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		<-ctx.Done()
+		slog.Info("Shutting down, waiting for running jobs")
+		server.Shutdown(context.Background())
+	}()
+
+	// Start HTTP server.
+	slog.Info("Listening", "port", port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logging.Fatal("Server failed", "error", err)
+	}
+	service.Wait()
+	if err := shutdownTelemetry(context.Background()); err != nil {
+		slog.Error("Error flushing telemetry", "error", err)
+	}
 }
@@ -0,0 +1,155 @@
+// Package proxy keeps a pool of download proxies and tracks their health, so a
+// failing proxy is rested while the others carry on.
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+)
+
+// Backoff after consecutive failures doubles from minCooldown up to maxCooldown.
+const (
+	minCooldown = 30 * time.Second
+	maxCooldown = 10 * time.Minute
+)
+
+// Proxy is one proxy endpoint. Its String form has no credentials and is safe to log.
+type Proxy struct {
+	url *url.URL
+}
+
+// URL returns the proxy URL with credentials, for yt-dlp's --proxy flag. Never log it.
+func (p *Proxy) URL() string {
+	return p.url.String()
+}
+
+func (p *Proxy) String() string {
+	return p.url.Scheme + "://" + p.url.Host
+}
+
+// Redact removes the proxy's credentials from s, such as yt-dlp output that echoes the proxy URL.
+func (p *Proxy) Redact(s string) string {
+	if p.url.User == nil {
+		return s
+	}
+	s = strings.ReplaceAll(s, p.URL(), p.url.Redacted())
+	if password, ok := p.url.User.Password(); ok && password != "" {
+		s = strings.ReplaceAll(s, url.QueryEscape(password), "xxxxx")
+		s = strings.ReplaceAll(s, password, "xxxxx")
+	}
+	return s
+}
+
+type member struct {
+	proxy     *Proxy
+	failures  int
+	downUntil time.Time
+}
+
+// Pool hands out proxies, preferring healthy ones, and rests a proxy after it fails.
+// It is safe for concurrent use.
+type Pool struct {
+	mu      sync.Mutex
+	members []*member
+	// next rotates which healthy proxy is tried first so load is spread across the pool.
+	next int
+	now  func() time.Time
+}
+
+// NewPool creates a pool from the configured proxies, in order.
+func NewPool(proxies []config.Proxy) (*Pool, error) {
+	pool := &Pool{now: time.Now}
+	for _, cfg := range proxies {
+		u, err := cfg.ParseURL()
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %v", err)
+		}
+		pool.members = append(pool.members, &member{proxy: &Proxy{url: u}})
+	}
+	return pool, nil
+}
+
+// Len returns the number of proxies in the pool.
+func (p *Pool) Len() int {
+	return len(p.members)
+}
+
+// Order returns every proxy in the order they should be tried: prefer first if it is
+// healthy, then the healthy proxies in rotation, then the resting ones, soonest back
+// first, as a last resort.
+func (p *Pool) Order(prefer *Proxy) []*Proxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var healthy, resting []*member
+	for i := range p.members {
+		m := p.members[(p.next+i)%len(p.members)]
+		if now.Before(m.downUntil) {
+			resting = append(resting, m)
+		} else {
+			healthy = append(healthy, m)
+		}
+	}
+	if len(p.members) > 0 {
+		p.next = (p.next + 1) % len(p.members)
+	}
+	sort.SliceStable(resting, func(i, j int) bool {
+		return resting[i].downUntil.Before(resting[j].downUntil)
+	})
+
+	var order []*Proxy
+	for _, m := range healthy {
+		if m.proxy == prefer {
+			order = append([]*Proxy{m.proxy}, order...)
+		} else {
+			order = append(order, m.proxy)
+		}
+	}
+	for _, m := range resting {
+		order = append(order, m.proxy)
+	}
+	return order
+}
+
+// Succeeded marks the proxy healthy.
+func (p *Pool) Succeeded(proxy *Proxy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if m := p.member(proxy); m != nil {
+		m.failures = 0
+		m.downUntil = time.Time{}
+	}
+}
+
+// Failed rests the proxy, for longer after each consecutive failure, and returns how long.
+func (p *Pool) Failed(proxy *Proxy) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := p.member(proxy)
+	if m == nil {
+		return 0
+	}
+	m.failures++
+	cooldown := minCooldown << min(m.failures-1, 10)
+	if cooldown > maxCooldown {
+		cooldown = maxCooldown
+	}
+	m.downUntil = p.now().Add(cooldown)
+	return cooldown
+}
+
+func (p *Pool) member(proxy *Proxy) *member {
+	for _, m := range p.members {
+		if m.proxy == proxy {
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ytdlp
+
+import "strings"
+
+// Failure classifies why yt-dlp failed.
+type Failure string
+
+const (
+	FailureUnsupportedURL Failure = "unsupported_url"
+	FailurePrivate        Failure = "private"
+	FailureUnavailable    Failure = "unavailable"
+	FailureGeoBlocked     Failure = "geo_blocked"
+	// FailureTooLarge and FailureFiltered are skips by --max-filesize and --match-filter.
+	FailureTooLarge Failure = "too_large"
+	FailureFiltered Failure = "filtered"
+	FailureNetwork  Failure = "network"
+	FailureUnknown  Failure = "unknown"
+)
+
+// failurePatterns are matched in order against the lowercased error, so the more
+// specific failures come before the network errors they are often reported with.
+// Proxy failures come before unavailable so a 403 from the proxy is told apart from
+// a 403 from the site, which is the video being forbidden.
+var failurePatterns = []struct {
+	failure  Failure
+	patterns []string
+}{
+	{FailureGeoBlocked, []string{"geo restriction", "geo-restricted", "available in your country", "not available from your location"}},
+	{FailurePrivate, []string{"private video", "video is private", "login required", "log in to", "sign in to view", "requires authentication", "members-only", "age-restricted"}},
+	{FailureUnsupportedURL, []string{"unsupported url", "is not a valid url", "no suitable extractor"}},
+	{FailureNetwork, []string{"unable to connect to proxy", "tunnel connection failed", "proxyerror", "proxy error", "http error 407"}},
+	{FailureUnavailable, []string{"video unavailable", "has been removed", "no longer available", "does not exist", "http error 404", "http error 403", "no video formats found", "requested format is not available"}},
+	{FailureTooLarge, []string{"larger than max-filesize"}},
+	{FailureFiltered, []string{"does not pass filter"}},
+	{FailureNetwork, []string{
+		"connection refused", "connection reset", "remote end closed connection", "timed out",
+		"temporary failure in name resolution", "network is unreachable",
+		"http error 429", "sign in to confirm you",
+	}},
+}
+
+// Classify returns why yt-dlp failed from its output. The last ERROR line is used
+// when there is one, since warnings earlier in the output often mention retries.
+func Classify(output string) Failure {
+	text := strings.ToLower(output)
+	lines := strings.Split(text, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.HasPrefix(strings.TrimSpace(lines[i]), "error:") {
+			text = lines[i]
+			break
+		}
+	}
+
+	for _, class := range failurePatterns {
+		for _, pattern := range class.patterns {
+			if strings.Contains(text, pattern) {
+				return class.failure
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// Retryable reports whether yt-dlp's output shows a network or geo-block failure
+// that a different proxy might get past.
+func Retryable(output string) bool {
+	failure := Classify(output)
+	return failure == FailureNetwork || failure == FailureGeoBlocked
+}
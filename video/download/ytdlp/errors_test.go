@@ -0,0 +1,41 @@
+package ytdlp
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		output string
+		want   Failure
+	}{
+		{"ERROR: [youtube] abc: Video unavailable", FailureUnavailable},
+		{"ERROR: [generic] Unsupported URL: https://example.com", FailureUnsupportedURL},
+		{"ERROR: [youtube] abc: Private video. Sign in if you've been granted access", FailurePrivate},
+		{"ERROR: [youtube] abc: The uploader has not made this video available in your country", FailureGeoBlocked},
+		{"ERROR: [youtube] abc: Sign in to confirm you're not a bot", FailureNetwork},
+		{"ERROR: unable to download video data: HTTP Error 429: Too Many Requests", FailureNetwork},
+		{"ERROR: unable to download video data: HTTP Error 403: Forbidden", FailureUnavailable},
+		{"ERROR: [twitter] 123: Unable to download JSON metadata: HTTP Error 404: Not Found", FailureUnavailable},
+		{"ERROR: Unable to download webpage: HTTP Error 407: Proxy Authentication Required", FailureNetwork},
+		{"ERROR: Unable to download webpage: ('Unable to connect to proxy', OSError('Tunnel connection failed: 403 Forbidden'))", FailureNetwork},
+		{"ERROR: Unable to download webpage: HTTP Error 403: Forbidden (caused by ProxyError('Cannot connect to proxy.'))", FailureNetwork},
+		{"ERROR: Unable to download webpage: <urlopen error [Errno 111] Connection refused>", FailureNetwork},
+		{"ERROR: [download] File is larger than max-filesize (1000 bytes > 10 bytes). Aborting.", FailureTooLarge},
+		{"ERROR: something new went wrong", FailureUnknown},
+		// Warnings about retries before the last ERROR line don't decide the class
+		{"WARNING: [youtube] Unable to download webpage: timed out. Retrying\nERROR: [youtube] abc: Video unavailable", FailureUnavailable},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.output); got != tt.want {
+			t.Errorf("Classify(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	if Retryable("ERROR: unable to download video data: HTTP Error 403: Forbidden") {
+		t.Error("a 403 from the site is retried through another proxy")
+	}
+	if !Retryable("ERROR: Unable to download webpage: ('Unable to connect to proxy', OSError('Tunnel connection failed: 403 Forbidden'))") {
+		t.Error("a 403 from the proxy is not retried through another proxy")
+	}
+}
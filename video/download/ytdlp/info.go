@@ -0,0 +1,113 @@
+package ytdlp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Info is the subset of yt-dlp's --dump-json output the download service uses.
+type Info struct {
+	ID         string  `json:"id"`
+	Extractor  string  `json:"extractor"`
+	Duration   float64 `json:"duration"`
+	Uploader   string  `json:"uploader"`
+	WebpageURL string  `json:"webpage_url"`
+	IsLive     bool    `json:"is_live"`
+	// LiveStatus is one of not_live, is_live, is_upcoming, was_live or post_live when known.
+	LiveStatus string `json:"live_status"`
+	// Filesize is exact when the site reports it, otherwise FilesizeApprox may be set.
+	Filesize       int64 `json:"filesize"`
+	FilesizeApprox int64 `json:"filesize_approx"`
+	// RequestedFormats are the formats merged into the download, when there is more than one.
+	RequestedFormats []struct {
+		Filesize       int64 `json:"filesize"`
+		FilesizeApprox int64 `json:"filesize_approx"`
+	} `json:"requested_formats"`
+}
+
+// Size estimates the download size in bytes from the selected formats, or returns 0 if unknown.
+func (i *Info) Size() int64 {
+	if len(i.RequestedFormats) > 0 {
+		var total int64
+		for _, format := range i.RequestedFormats {
+			size := format.Filesize
+			if size == 0 {
+				size = format.FilesizeApprox
+			}
+			if size == 0 {
+				return 0
+			}
+			total += size
+		}
+		return total
+	}
+	if i.Filesize > 0 {
+		return i.Filesize
+	}
+	return i.FilesizeApprox
+}
+
+// Live reports whether the URL is a live stream or one that hasn't started.
+func (i *Info) Live() bool {
+	return i.IsLive || i.LiveStatus == "is_live" || i.LiveStatus == "is_upcoming"
+}
+
+// Rejection reasons.
+const (
+	ReasonLive     = "live_stream"
+	ReasonTooLong  = "too_long"
+	ReasonTooLarge = "too_large"
+)
+
+// Rejection is returned when a URL is outside the limits.
+type Rejection struct {
+	Reason  string
+	Message string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+// AsRejection returns the rejection wrapped in err, if any.
+func AsRejection(err error) (*Rejection, bool) {
+	var rejection *Rejection
+	ok := errors.As(err, &rejection)
+	return rejection, ok
+}
+
+// Check rejects the video if it is live or known to exceed the limits. Unknown sizes
+// pass here and are enforced by --max-filesize during the download.
+func (l Limits) Check(info *Info) error {
+	if info.Live() {
+		return &Rejection{Reason: ReasonLive, Message: "live streams can't be downloaded"}
+	}
+	if l.MaxDuration > 0 && info.Duration > l.MaxDuration {
+		return &Rejection{Reason: ReasonTooLong, Message: fmt.Sprintf("video is %.0fs long, the limit is %.0fs", info.Duration, l.MaxDuration)}
+	}
+	if size := info.Size(); l.MaxFilesize > 0 && size > l.MaxFilesize {
+		return &Rejection{Reason: ReasonTooLarge, Message: fmt.Sprintf("video is about %d MiB, the limit is %d MiB", size>>20, l.MaxFilesize>>20)}
+	}
+	return nil
+}
+
+// FetchInfo runs yt-dlp with --dump-json to read the URL's metadata. The returned
+// output is yt-dlp's stderr, for diagnosing failures.
+func (o *Options) FetchInfo(ctx context.Context, url string) (*Info, string, error) {
+	cmd := o.Command(ctx, o.InfoArgs(url))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, stderr.String(), fmt.Errorf("cmd.Run: %v", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		return nil, stderr.String(), fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	return &info, stderr.String(), nil
+}
@@ -0,0 +1,93 @@
+package ytdlp
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// parseInfo decodes a --dump-json excerpt.
+func parseInfo(t *testing.T, s string) *Info {
+	t.Helper()
+	var info Info
+	if err := json.Unmarshal([]byte(s), &info); err != nil {
+		t.Fatal(err)
+	}
+	return &info
+}
+
+func TestInfoSize(t *testing.T) {
+	tests := []struct {
+		name string
+		info string
+		want int64
+	}{
+		{"unknown", `{}`, 0},
+		{"exact", `{"filesize": 1000, "filesize_approx": 900}`, 1000},
+		{"approximate", `{"filesize_approx": 900}`, 900},
+		{"merged formats", `{"filesize": 5, "requested_formats": [{"filesize": 1000}, {"filesize_approx": 200}]}`, 1200},
+		// One format of unknown size makes the total unknown rather than too small
+		{"merged format of unknown size", `{"filesize": 5, "requested_formats": [{"filesize": 1000}, {}]}`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseInfo(t, tt.info).Size(); got != tt.want {
+				t.Errorf("Size() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfoLive(t *testing.T) {
+	tests := []struct {
+		info string
+		want bool
+	}{
+		{`{}`, false},
+		{`{"is_live": true}`, true},
+		{`{"live_status": "is_live"}`, true},
+		// A premiere or scheduled stream has nothing to download yet
+		{`{"live_status": "is_upcoming"}`, true},
+		// A finished stream is an ordinary video
+		{`{"live_status": "was_live"}`, false},
+		{`{"live_status": "post_live"}`, false},
+		{`{"live_status": "not_live"}`, false},
+	}
+	for _, tt := range tests {
+		if got := parseInfo(t, tt.info).Live(); got != tt.want {
+			t.Errorf("%s Live() = %v, want %v", tt.info, got, tt.want)
+		}
+	}
+}
+
+func TestLimitsCheck(t *testing.T) {
+	limits := Limits{MaxFilesize: 100 << 20, MaxDuration: 300}
+	tests := []struct {
+		name   string
+		limits Limits
+		info   string
+		reason string
+	}{
+		{"within the limits", limits, `{"duration": 30, "filesize": 1048576}`, ""},
+		{"at the limits", limits, `{"duration": 300, "filesize": 104857600}`, ""},
+		{"live", limits, `{"live_status": "is_upcoming"}`, ReasonLive},
+		{"too long", limits, `{"duration": 301}`, ReasonTooLong},
+		{"too large", limits, `{"duration": 30, "filesize_approx": 104857601}`, ReasonTooLarge},
+		// An unknown size is left to --max-filesize
+		{"unknown size", limits, `{"duration": 30}`, ""},
+		{"unlimited", Limits{}, `{"duration": 86400, "filesize": 10737418240}`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.Check(parseInfo(t, tt.info))
+			reason := ""
+			if rejection, ok := AsRejection(err); ok {
+				reason = rejection.Reason
+			} else if err != nil {
+				t.Fatalf("Check = %v, want a *Rejection", err)
+			}
+			if reason != tt.reason {
+				t.Errorf("Check = %v, want reason %q", err, tt.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+// Package ytdlp builds yt-dlp invocations from typed options, and fetches a URL's
+// metadata before downloading so oversize, overlong and live videos are rejected
+// without transferring any of the video.
+package ytdlp
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+)
+
+// Options are the yt-dlp settings shared by the metadata fetch and the download.
+type Options struct {
+	// Binary is the path to yt-dlp.
+	Binary string
+	// Format is the format selector passed to --format.
+	Format string
+	// Proxy is a proxy URL including any credentials, so it must not be logged. Empty connects directly.
+	Proxy string
+	// CookiesFile is a Netscape format cookies file. Empty sends no cookies.
+	CookiesFile string
+	// CheckCertificates verifies TLS certificates. When false --no-check-certificates is passed.
+	CheckCertificates bool
+	// RateLimit is passed to --limit-rate, e.g. 2M. Empty is unlimited.
+	RateLimit string
+	Limits    Limits
+}
+
+// Limits bound what will be downloaded. Zero values are unlimited.
+type Limits struct {
+	// MaxFilesize is in bytes.
+	MaxFilesize int64
+	// MaxDuration is in seconds.
+	MaxDuration float64
+}
+
+// Command returns the yt-dlp command for args. It is killed, along with the ffmpeg
+// it starts to merge formats, when ctx is done.
+func (o *Options) Command(ctx context.Context, args []string) *exec.Cmd {
+	return deadline.Command(ctx, o.Binary, args...)
+}
+
+// commonArgs are the flags used for both the metadata fetch and the download.
+func (o *Options) commonArgs() []string {
+	args := []string{"--format", o.Format, "--no-playlist"}
+	if o.Proxy != "" {
+		args = append(args, "--proxy", o.Proxy)
+	}
+	if o.CookiesFile != "" {
+		args = append(args, "--cookies", o.CookiesFile)
+	}
+	if !o.CheckCertificates {
+		args = append(args, "--no-check-certificates")
+	}
+	return args
+}
+
+// InfoArgs returns the arguments that print the URL's metadata as JSON without downloading.
+func (o *Options) InfoArgs(url string) []string {
+	args := append(o.commonArgs(), "--dump-json")
+	// "--" stops a URL starting with a dash from being read as a flag
+	return append(args, "--", url)
+}
+
+// DownloadArgs returns the arguments that download the URL to outputTemplate and
+// print the final file path on stdout. The limits are passed on as well, since
+// the metadata can't always tell the size in advance.
+func (o *Options) DownloadArgs(url, outputTemplate string) []string {
+	args := append(o.commonArgs(), "-o", outputTemplate, "--restrict-filenames", "--print", "after_move:filepath")
+	if o.RateLimit != "" {
+		args = append(args, "--limit-rate", o.RateLimit)
+	}
+	if o.Limits.MaxFilesize > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(o.Limits.MaxFilesize, 10))
+	}
+	filter := "!is_live"
+	if o.Limits.MaxDuration > 0 {
+		filter += fmt.Sprintf(" & duration<=%g", o.Limits.MaxDuration)
+	}
+	args = append(args, "--match-filter", filter)
+	return append(args, "--", url)
+}
@@ -0,0 +1,44 @@
+package ytdlp
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDownloadArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			"defaults",
+			Options{Format: "best", CheckCertificates: true},
+			[]string{"--format", "best", "--no-playlist", "-o", "/tmp/w/%(id)s.%(ext)s", "--restrict-filenames", "--print", "after_move:filepath",
+				"--match-filter", "!is_live", "--", "-dashed-url"},
+		},
+		{
+			"everything",
+			Options{Format: "best", Proxy: "http://u:p@proxy:8080", CookiesFile: "/c.txt", RateLimit: "2M", Limits: Limits{MaxFilesize: 1 << 20, MaxDuration: 90.5}},
+			[]string{"--format", "best", "--no-playlist", "--proxy", "http://u:p@proxy:8080", "--cookies", "/c.txt", "--no-check-certificates",
+				"-o", "/tmp/w/%(id)s.%(ext)s", "--restrict-filenames", "--print", "after_move:filepath",
+				"--limit-rate", "2M", "--max-filesize", "1048576", "--match-filter", "!is_live & duration<=90.5", "--", "-dashed-url"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A URL starting with a dash must still come after the --
+			if got := tt.opts.DownloadArgs("-dashed-url", "/tmp/w/%(id)s.%(ext)s"); !slices.Equal(got, tt.want) {
+				t.Errorf("DownloadArgs =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfoArgs(t *testing.T) {
+	opts := Options{Format: "best", CheckCertificates: true, Limits: Limits{MaxDuration: 60}}
+	want := []string{"--format", "best", "--no-playlist", "--dump-json", "--", "https://example.com/v"}
+	if got := opts.InfoArgs("https://example.com/v"); !slices.Equal(got, want) {
+		t.Errorf("InfoArgs = %q, want %q", got, want)
+	}
+}
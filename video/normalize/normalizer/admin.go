@@ -0,0 +1,139 @@
+package normalizer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+)
+
+// FailedVideo is a video normalize gave up on.
+type FailedVideo struct {
+	// Name is the video's name in the bucket it came from, which it is requeued under.
+	Name         string `json:"name"`
+	Bucket       string `json:"bucket"`
+	Object       string `json:"object"`
+	SourceBucket string `json:"sourceBucket"`
+	Size         int64  `json:"size"`
+	Attempts     int    `json:"attempts"`
+	Error        string `json:"error,omitempty"`
+	FFmpegStderr string `json:"ffmpegStderr,omitempty"`
+	Probe        string `json:"probe,omitempty"`
+	FailedAt     string `json:"failedAt,omitempty"`
+}
+
+// AdminHandler serves the endpoints for videos normalize gave up on:
+//
+//	GET  /failed              lists them
+//	POST /requeue/{name...}   moves one back to the bucket it came from to be tried again
+//
+// They have no authentication of their own, so only expose them to administrators.
+func (s *Service) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /failed", s.listFailedHandler)
+	mux.HandleFunc("POST /requeue/{name...}", s.requeueHandler)
+	return mux
+}
+
+func (s *Service) listFailedHandler(w http.ResponseWriter, r *http.Request) {
+	failed, err := s.ListFailed(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing failed videos", "error", err)
+		http.Error(w, "Error listing failed videos", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]*FailedVideo{"failed": failed})
+}
+
+func (s *Service) requeueHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	attrs, err := s.Requeue(r.Context(), name)
+	switch {
+	case errors.Is(err, blobstore.ErrNotExist):
+		http.Error(w, "No failed video with that name", http.StatusNotFound)
+		return
+	case errors.Is(err, blobstore.ErrPreconditionFailed):
+		http.Error(w, "A video with that name is already waiting to be normalized", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Error requeueing failed video", "object", name, "error", err)
+		http.Error(w, "Error requeueing failed video", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Requeued failed video", "bucket", attrs.Bucket, "object", attrs.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"bucket": attrs.Bucket, "name": attrs.Name})
+}
+
+// ListFailed returns every video in the failed bucket.
+func (s *Service) ListFailed(ctx context.Context) ([]*FailedVideo, error) {
+	bucket := s.failedBucket(s.Config.Buckets.Quarantine)
+	objects, err := blobstore.ListAll(ctx, s.Store, bucket, deadLetterPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("blobstore.ListAll: %v", err)
+	}
+
+	failed := make([]*FailedVideo, 0, len(objects))
+	for _, object := range objects {
+		failed = append(failed, &FailedVideo{
+			Name:         strings.TrimPrefix(object.Name, deadLetterPrefix),
+			Bucket:       object.Bucket,
+			Object:       object.Name,
+			SourceBucket: s.sourceBucket(object),
+			Size:         object.Size,
+			Attempts:     attempts(object.Metadata),
+			Error:        object.Metadata[metadata.KeyNormalizeError],
+			FFmpegStderr: object.Metadata[metadata.KeyFFmpegStderr],
+			Probe:        object.Metadata[metadata.KeyProbeSummary],
+			FailedAt:     object.Metadata[metadata.KeyFailedAt],
+		})
+	}
+	return failed, nil
+}
+
+// Requeue moves a failed video back to the bucket it came from with its attempts
+// reset, which raises a new event for normalize. It fails with ErrPreconditionFailed
+// rather than overwrite a video of the same name waiting there.
+func (s *Service) Requeue(ctx context.Context, name string) (*blobstore.Attrs, error) {
+	bucket := s.failedBucket(s.Config.Buckets.Quarantine)
+	object := deadLetterPrefix + name
+	failed, err := s.Store.Stat(ctx, bucket, object)
+	if err != nil {
+		return nil, fmt.Errorf("Store.Stat: %w", err)
+	}
+	reader, err := s.Store.Get(ctx, bucket, object)
+	if err != nil {
+		return nil, fmt.Errorf("Store.Get: %w", err)
+	}
+	defer reader.Close()
+
+	attrs, err := s.Store.Put(ctx, s.sourceBucket(failed), name, reader, &blobstore.PutOptions{
+		ContentType: failed.ContentType,
+		Metadata:    withoutFailure(failed.Metadata),
+		IfNotExists: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Store.Put: %w", err)
+	}
+	if err := s.Store.Delete(ctx, bucket, object); err != nil && !errors.Is(err, blobstore.ErrNotExist) {
+		return nil, fmt.Errorf("Store.Delete: %v", err)
+	}
+	return attrs, nil
+}
+
+// sourceBucket is the bucket a failed video came from, falling back to quarantine
+// for videos moved there by hand.
+func (s *Service) sourceBucket(failed *blobstore.Attrs) string {
+	if bucket := failed.Metadata[metadata.KeySourceBucket]; bucket != "" {
+		return bucket
+	}
+	return s.Config.Buckets.Quarantine
+}
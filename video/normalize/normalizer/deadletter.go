@@ -0,0 +1,176 @@
+package normalizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/ffmpeg"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/probe"
+)
+
+// A video that can't be normalized would otherwise sit in quarantine, or go round
+// the Eventarc retries forever. Every attempt is counted on the source object, and
+// once a video has used up its attempts, or ffprobe rejects it outright, it is moved
+// to the failed bucket with the error, the end of ffmpeg's output and the probe
+// summary attached, where the admin endpoints can list and requeue it.
+
+// deadLetterPrefix is prepended to the name of a video when it is moved to the failed bucket.
+const deadLetterPrefix = "failed/"
+
+const (
+	// maxStderrTail is how much of ffmpeg's stderr is recorded. Cloud Storage allows 8 KiB
+	// of custom metadata per object, which is shared with the clip's attribution.
+	maxStderrTail = 2 << 10
+	// maxErrorLength caps the error recorded in metadata.
+	maxErrorLength = 512
+)
+
+// errAttemptsExhausted is returned when an event arrives for a video that has already
+// used up its attempts, usually because each one crashed or timed out.
+var errAttemptsExhausted = errors.New("no attempt finished")
+
+// failure is what an attempt found out before it failed.
+type failure struct {
+	// attempt is the attempt's number, counting from 1. Zero means it failed before
+	// the attempt was counted, and it is retried without counting against the video.
+	attempt int
+	// probe is ffprobe's summary of the source once it has been probed.
+	probe string
+}
+
+// attempts returns how many times normalize has tried the source.
+func attempts(m map[string]string) int {
+	n, _ := strconv.Atoi(m[metadata.KeyNormalizeAttempts])
+	return n
+}
+
+// countAttempt records another attempt on the source without rewriting it, and
+// returns the attempt's number.
+func (s *Service) countAttempt(ctx context.Context, data StorageObjectData, source *blobstore.Attrs) (int, error) {
+	attempt := attempts(source.Metadata) + 1
+	_, err := s.Store.Update(ctx, data.Bucket, data.Name, map[string]string{
+		metadata.KeyNormalizeAttempts: strconv.Itoa(attempt),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Store.Update: %v", err)
+	}
+	return attempt, nil
+}
+
+// failed handles an attempt that returned err. The error is recorded on the source,
+// which is moved to the failed bucket when ffprobe rejected it or it has used up its
+// attempts. Otherwise the error is returned so the event is retried.
+func (s *Service) failed(ctx context.Context, logger *slog.Logger, data StorageObjectData, source *blobstore.Attrs, f *failure, err error) (string, error) {
+	if f.attempt == 0 {
+		return outcomeFailed, err
+	}
+	if updateErr := s.recordFailure(ctx, data, source, f, err); updateErr != nil {
+		logger.WarnContext(ctx, "Error recording the failure on the source", "error", updateErr)
+	}
+
+	_, rejected := probe.AsRejection(err)
+	if !rejected && f.attempt < s.Config.Normalize.MaxAttempts {
+		logger.WarnContext(ctx, "Normalize attempt failed, the event will be retried",
+			"attempt", f.attempt, "maxAttempts", s.Config.Normalize.MaxAttempts, "error", err)
+		return outcomeFailed, err
+	}
+
+	// If the move fails the retry finds the attempts used up and tries again
+	failedBucket := s.failedBucket(data.Bucket)
+	if dlErr := s.deadLetter(ctx, data, failedBucket); dlErr != nil {
+		logger.ErrorContext(ctx, "Error moving video to the failed bucket", "error", dlErr)
+		return outcomeFailed, err
+	}
+	logger.WarnContext(ctx, "Moved video to the failed bucket", "failedBucket", failedBucket,
+		"failedObject", deadLetterPrefix+data.Name, "attempt", f.attempt, "error", err)
+	if rejected {
+		return outcomeRejected, nil
+	}
+	return outcomeDeadLettered, nil
+}
+
+// recordFailure stores the attempt's error, ffmpeg output and probe summary on the source.
+func (s *Service) recordFailure(ctx context.Context, data StorageObjectData, source *blobstore.Attrs, f *failure, err error) error {
+	update := make(map[string]string)
+	if f.probe != "" {
+		update[metadata.KeyProbeSummary] = f.probe
+	}
+	// Keep what the last attempt to finish recorded rather than overwrite it with
+	// the less useful fact that the later ones didn't
+	if !errors.Is(err, errAttemptsExhausted) || source.Metadata[metadata.KeyNormalizeError] == "" {
+		update[metadata.KeyNormalizeError] = truncate(err.Error(), maxErrorLength)
+		// An empty value clears the output of an earlier attempt's ffmpeg failure
+		update[metadata.KeyFFmpegStderr] = ""
+		var ffErr *ffmpeg.Error
+		if errors.As(err, &ffErr) {
+			update[metadata.KeyFFmpegStderr] = ffmpeg.Tail(ffErr.Stderr, maxStderrTail)
+		}
+	}
+	if _, err := s.Store.Update(ctx, data.Bucket, data.Name, update); err != nil {
+		return fmt.Errorf("Store.Update: %v", err)
+	}
+	return nil
+}
+
+// deadLetter copies the source to the failed bucket, under deadLetterPrefix, and deletes it.
+func (s *Service) deadLetter(ctx context.Context, data StorageObjectData, failedBucket string) error {
+	source, err := s.Store.Stat(ctx, data.Bucket, data.Name)
+	if err != nil {
+		return fmt.Errorf("Store.Stat: %v", err)
+	}
+	reader, err := s.Store.Get(ctx, data.Bucket, data.Name)
+	if err != nil {
+		return fmt.Errorf("Store.Get: %v", err)
+	}
+	defer reader.Close()
+
+	_, err = s.Store.Put(ctx, failedBucket, deadLetterPrefix+data.Name, reader, &blobstore.PutOptions{
+		ContentType: source.ContentType,
+		Metadata: metadata.Merge(map[string]string{
+			metadata.KeySourceBucket: data.Bucket,
+			metadata.KeyFailedAt:     time.Now().UTC().Format(time.RFC3339),
+		}, source.Metadata),
+	})
+	if err != nil {
+		return fmt.Errorf("Store.Put: %v", err)
+	}
+	return s.deleteSource(ctx, data.Bucket, data.Name)
+}
+
+// failedBucket is where videos from the source bucket go when they fail.
+func (s *Service) failedBucket(source string) string {
+	if s.Config.Buckets.Failed != "" {
+		return s.Config.Buckets.Failed
+	}
+	return source
+}
+
+// withoutFailure returns a copy of m without the keys recorded for failed attempts,
+// so they aren't carried on to the normalized clip or a requeued video.
+func withoutFailure(m map[string]string) map[string]string {
+	clean := make(map[string]string, len(m))
+	for k, v := range m {
+		switch k {
+		case metadata.KeyNormalizeAttempts, metadata.KeyNormalizeError, metadata.KeyFFmpegStderr,
+			metadata.KeyProbeSummary, metadata.KeySourceBucket, metadata.KeyFailedAt:
+			continue
+		}
+		clean[k] = v
+	}
+	return clean
+}
+
+// truncate shortens s to at most n bytes without splitting a character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n], "")
+}
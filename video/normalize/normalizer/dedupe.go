@@ -0,0 +1,54 @@
+package normalizer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/fingerprint"
+)
+
+func (s *Service) fingerprintIndex() *fingerprint.Index {
+	return &fingerprint.Index{Store: s.Store, Bucket: s.Config.Buckets.Fingerprints}
+}
+
+// findDuplicate fingerprints the normalized clip and looks for a near-duplicate in the
+// index. Dedupe is best effort: if anything fails the clip is published as an original
+// and the returned fingerprint is nil, so it isn't indexed either.
+func (s *Service) findDuplicate(ctx context.Context, name, path string) (*fingerprint.Fingerprint, *fingerprint.Match) {
+	dedupe := s.Config.Normalize.Dedupe
+
+	var fp *fingerprint.Fingerprint
+	err := s.ffmpeg(slog.Default()).Time(ctx, "fingerprint", func(ctx context.Context) error {
+		var err error
+		fp, err = fingerprint.Compute(ctx, path)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error fingerprinting, skipping dedupe", "object", name, "error", err)
+		return nil, nil
+	}
+
+	var since time.Time
+	if dedupe.WindowDays > 0 {
+		since = time.Now().AddDate(0, 0, -dedupe.WindowDays)
+	}
+	match, err := s.fingerprintIndex().FindDuplicate(ctx, name, fp, since, dedupe.Threshold, dedupe.AudioThreshold)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error searching the fingerprint index, skipping dedupe", "object", name, "error", err)
+		return nil, nil
+	}
+	if match != nil {
+		slog.InfoContext(ctx, "Found a near-duplicate", "object", name, "match", match.Name,
+			"video", match.Video, "audio", match.Audio, "hasAudio", match.HasAudio, "offset", match.Offset)
+	}
+	return fp, match
+}
+
+// indexFingerprint adds a published original to the index. A failure only means
+// later reposts of it won't be caught.
+func (s *Service) indexFingerprint(ctx context.Context, name string, fp *fingerprint.Fingerprint) {
+	if err := s.fingerprintIndex().Add(ctx, name, fp); err != nil {
+		slog.ErrorContext(ctx, "Error indexing fingerprint", "object", name, "error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package normalizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/trim"
+)
+
+// EBU R128 targets for the loudnorm filter.
+const (
+	loudnormTargetI   = -16.0
+	loudnormTargetTP  = -1.5
+	loudnormTargetLRA = 11.0
+)
+
+// loudnormStats is the JSON block loudnorm prints with print_format=json.
+// ffmpeg reports every value as a string.
+type loudnormStats struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// loudnormFilter returns the single-pass filter, used when the input can't be measured.
+func loudnormFilter() string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=summary", loudnormTargetI, loudnormTargetTP, loudnormTargetLRA)
+}
+
+// linearLoudnormFilter returns the second-pass filter that applies the measured stats in linear mode.
+// Single-pass loudnorm uses dynamic mode, which pumps on short clips and misses the target.
+func linearLoudnormFilter(stats *loudnormStats) string {
+	return fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true:print_format=summary",
+		loudnormTargetI, loudnormTargetTP, loudnormTargetLRA,
+		stats.InputI, stats.InputTP, stats.InputLRA, stats.InputThresh, stats.TargetOffset)
+}
+
+// parseLoudnormStats extracts the JSON block loudnorm prints near the end of ffmpeg's
+// stderr. The block follows the filter's log prefix, and is decoded on its own so
+// braces elsewhere in the output, like in a title or the muxer's summary, don't matter.
+func parseLoudnormStats(output []byte) (*loudnormStats, error) {
+	if prefix := bytes.LastIndex(output, []byte("[Parsed_loudnorm_")); prefix >= 0 {
+		output = output[prefix:]
+	}
+	start := bytes.IndexByte(output, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("no loudnorm stats in ffmpeg output")
+	}
+
+	var stats loudnormStats
+	if err := json.NewDecoder(bytes.NewReader(output[start:])).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("json.Decode: %v", err)
+	}
+
+	// Silent audio measures as -inf, which loudnorm rejects as a measured value
+	for name, value := range map[string]string{
+		"input_i":       stats.InputI,
+		"input_tp":      stats.InputTP,
+		"input_lra":     stats.InputLRA,
+		"input_thresh":  stats.InputThresh,
+		"target_offset": stats.TargetOffset,
+	} {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("unusable loudnorm %s %q", name, value)
+		}
+	}
+	return &stats, nil
+}
+
+// measuredLoudnormFilter measures the part of the input in clipRange and returns the loudnorm
+// filter for the encode pass, falling back to single-pass when the measurement is unusable.
+func (s *Service) measuredLoudnormFilter(ctx context.Context, logger *slog.Logger, inputFilePath string, clipRange trim.Range) string {
+	// The analysis pass prints the stats at the end of stderr, which the runner keeps
+	args := append(clipRange.InputArgs(), "-i", inputFilePath,
+		"-vn", "-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g:print_format=json", loudnormTargetI, loudnormTargetTP, loudnormTargetLRA),
+		"-f", "null", "-")
+	result, err := s.ffmpeg(logger).Run(ctx, "loudnorm", args)
+	var stats *loudnormStats
+	if err == nil {
+		stats, err = parseLoudnormStats([]byte(result.Stderr))
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Loudness analysis failed, falling back to single-pass loudnorm", "error", err)
+		return loudnormFilter()
+	}
+	logger.InfoContext(ctx, "Measured loudness", "i", stats.InputI, "tp", stats.InputTP,
+		"lra", stats.InputLRA, "thresh", stats.InputThresh, "offset", stats.TargetOffset)
+	return linearLoudnormFilter(stats)
+}
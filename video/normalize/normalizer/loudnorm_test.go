@@ -0,0 +1,73 @@
+package normalizer
+
+import "testing"
+
+// loudnormOutput is the end of ffmpeg's stderr for an analysis pass with
+// print_format=json, around the stats block.
+func loudnormOutput(stats, after string) string {
+	return `Input #0, mov,mp4,m4a,3gp,3g2,mj2, from 'in.mp4':
+  Metadata:
+    title           : {best} meme
+  Duration: 00:00:10.00, start: 0.000000, bitrate: 1043 kb/s
+Stream mapping:
+  Stream #0:1 -> #0:0 (aac (native) -> pcm_s16le (native))
+Output #0, null, to 'pipe:':
+[Parsed_loudnorm_0 @ 0x55d5c8e3c2c0] 
+` + stats + `
+[out#0/null @ 0x55d5c8e4a340] video:0KiB audio:1875KiB subtitle:0KiB other streams:0KiB global headers:0KiB muxing overhead: unknown
+` + after
+}
+
+const measuredStats = `{
+	"input_i" : "-23.54",
+	"input_tp" : "-7.96",
+	"input_lra" : "2.10",
+	"input_thresh" : "-34.17",
+	"output_i" : "-16.02",
+	"output_tp" : "-1.50",
+	"output_lra" : "1.90",
+	"output_thresh" : "-26.52",
+	"normalization_type" : "dynamic",
+	"target_offset" : "0.02"
+}`
+
+const silentStats = `{
+	"input_i" : "-inf",
+	"input_tp" : "-inf",
+	"input_lra" : "0.00",
+	"input_thresh" : "-70.00",
+	"output_i" : "-inf",
+	"output_tp" : "-inf",
+	"output_lra" : "0.00",
+	"output_thresh" : "-70.00",
+	"normalization_type" : "dynamic",
+	"target_offset" : "inf"
+}`
+
+func TestParseLoudnormStats(t *testing.T) {
+	measured := &loudnormStats{InputI: "-23.54", InputTP: "-7.96", InputLRA: "2.10", InputThresh: "-34.17", TargetOffset: "0.02"}
+	tests := []struct {
+		name    string
+		output  string
+		want    *loudnormStats
+		wantErr bool
+	}{
+		{"measured", loudnormOutput(measuredStats, ""), measured, false},
+		{"silent", loudnormOutput(silentStats, ""), nil, true},
+		// Braces after the block, like in a progress or summary line, are ignored
+		{"trailing braces", loudnormOutput(measuredStats, "[aac @ 0x55d5c8e4b000] {\n}\n"), measured, false},
+		{"no stats", loudnormOutput("", "in.mp4: Invalid data found when processing input\n"), nil, true},
+		{"truncated", loudnormOutput(measuredStats[:40], ""), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLoudnormStats([]byte(tt.output))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLoudnormStats error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.want != nil && *got != *tt.want {
+				t.Errorf("parseLoudnormStats = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package normalizer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/ffmpeg"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/DC00/meme-compiler-cloud-functions/video/normalize/normalizer"
+
+// Outcomes of a normalize invocation, recorded on the normalize.videos counter.
+const (
+	outcomeNormalized = "normalized"
+	// outcomeFlagged is a near-duplicate published with duplicate-of set.
+	outcomeFlagged = "flagged"
+	// outcomeSkipped is a near-duplicate deleted without publishing.
+	outcomeSkipped  = "skipped"
+	outcomeRejected = "rejected"
+	outcomeFailed   = "failed"
+	// outcomeRedelivered is an event whose clip was already published.
+	outcomeRedelivered = "redelivered"
+	// outcomeStale is an event for a source generation that has since been overwritten.
+	outcomeStale = "stale"
+	// outcomeDeadLettered is a video moved to the failed bucket after its last attempt.
+	outcomeDeadLettered = "dead_lettered"
+)
+
+var (
+	tracer = otel.Tracer(instrumentationName)
+	meter  = otel.Meter(instrumentationName)
+
+	videos = telemetry.Must(meter.Int64Counter("normalize.videos",
+		metric.WithDescription("Normalize invocations by outcome.")))
+	videoDuration = telemetry.Must(meter.Float64Histogram("normalize.duration",
+		metric.WithDescription("Time to normalize a video, from reading the input to deleting it."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+	ffmpegDuration = telemetry.Must(meter.Float64Histogram("normalize.ffmpeg.duration",
+		metric.WithDescription("ffmpeg runtime by step (loudnorm, encode or fingerprint) and outcome."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(telemetry.DurationBuckets...)))
+)
+
+// recordVideo records an invocation's outcome and duration.
+func recordVideo(ctx context.Context, outcome string, start time.Time) {
+	attrs := metric.WithAttributes(attribute.String("outcome", outcome))
+	videos.Add(ctx, 1, attrs)
+	videoDuration.Record(ctx, telemetry.Seconds(start), attrs)
+}
+
+// ffmpeg returns the runner for the service's ffmpeg steps, logging to logger.
+func (s *Service) ffmpeg(logger *slog.Logger) *ffmpeg.Runner {
+	return &ffmpeg.Runner{
+		Tracer:   tracer,
+		Duration: ffmpegDuration,
+		Logger:   logger,
+		Timeout:  s.Config.FFmpeg.TimeoutDuration(),
+	}
+}
@@ -0,0 +1,319 @@
+// Package normalizer implements the normalize function: it re-encodes a video
+// from the quarantine bucket to a common size, frame rate and loudness.
+package normalizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/deadline"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/encoder"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/fingerprint"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/logging"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/probe"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/telemetry"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type StorageObjectData struct {
+	Bucket string `json:"bucket,omitempty"`
+	Name   string `json:"name,omitempty"`
+	// Generation is the generation of the object the event was raised for. Cloud Storage
+	// sends it as a string.
+	Generation string `json:"generation,omitempty"`
+}
+
+// Service normalizes videos and writes them to the normalized bucket on Store.
+type Service struct {
+	Store  blobstore.Store
+	Config *config.Config
+}
+
+// New creates a normalize service from a validated configuration.
+func New(store blobstore.Store, cfg *config.Config) *Service {
+	return &Service{Store: store, Config: cfg}
+}
+
+// NormalizeVideo handles the storage object finalized CloudEvent for a new video.
+func (s *Service) NormalizeVideo(ctx context.Context, e event.Event) error {
+	var data StorageObjectData
+	if err := e.DataAs(&data); err != nil {
+		slog.ErrorContext(ctx, "Error parsing CloudEvent data", "event", e.ID(), "error", err)
+		return fmt.Errorf("event.DataAs: %v", err)
+	}
+	logger := slog.With("bucket", data.Bucket, "object", data.Name)
+	ctx, cancel := deadline.WithTimeout(ctx, s.Config.Normalize.TimeoutDuration())
+	defer cancel()
+
+	// Without a failed bucket, videos that fail are moved alongside the ones waiting
+	// in quarantine, which raises events for them too
+	if strings.HasPrefix(data.Name, deadLetterPrefix) {
+		logger.DebugContext(ctx, "Ignoring a video moved to the failed bucket", "event", e.ID())
+		return nil
+	}
+
+	// Keep the source's attribution metadata so concatenate can credit the clip.
+	// The source is only deleted once its clip is published or skipped, so if it's
+	// gone this is a redelivery of an event that was already handled.
+	inputAttrs, err := s.Store.Stat(ctx, data.Bucket, data.Name)
+	if errors.Is(err, blobstore.ErrNotExist) {
+		logger.InfoContext(ctx, "Source object is gone, the event was already handled", "event", e.ID())
+		recordVideo(ctx, outcomeRedelivered, time.Now())
+		return nil
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Error reading input object attributes", "error", err)
+		return fmt.Errorf("Store.Stat: %v", err)
+	}
+
+	// Log under the correlation ID download stored on the object, which metadata.Merge
+	// below carries on to the normalized clip. Videos copied in by hand get a new one.
+	correlationID := inputAttrs.Metadata[metadata.KeyCorrelationID]
+	if correlationID == "" {
+		correlationID = logging.NewID()
+	}
+	ctx = logging.WithID(ctx, correlationID)
+	logger.InfoContext(ctx, "Normalizing video", "event", e.ID())
+
+	ctx, span := telemetry.Start(ctx, tracer, "normalize", trace.WithAttributes(
+		attribute.String("bucket", data.Bucket),
+		attribute.String("object", data.Name)))
+	start := time.Now()
+	var f failure
+	outcome, err := s.normalize(ctx, logger, data, inputAttrs, correlationID, &f)
+	if err != nil {
+		outcome, err = s.failed(ctx, logger, data, inputAttrs, &f, err)
+	}
+	recordVideo(ctx, outcome, start)
+	span.SetAttributes(attribute.String("outcome", outcome))
+	telemetry.End(span, err)
+	return err
+}
+
+// normalize re-encodes the input object and publishes it, returning the outcome for
+// the metrics. What it learns before failing is recorded in f.
+func (s *Service) normalize(ctx context.Context, logger *slog.Logger, data StorageObjectData, inputAttrs *blobstore.Attrs, correlationID string, f *failure) (string, error) {
+	if staleEvent(data, inputAttrs) {
+		logger.InfoContext(ctx, "Source was overwritten since the event, leaving it to the newer event",
+			"eventGeneration", data.Generation, "generation", inputAttrs.Generation)
+		return outcomeStale, nil
+	}
+
+	// An earlier delivery may have published the clip and stopped before deleting the source
+	existing, putOptions, err := s.published(ctx, data.Name, inputAttrs.Generation)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error checking for a published clip", "error", err)
+		return "", err
+	}
+	if existing != nil {
+		logger.InfoContext(ctx, "Clip already published from this source, deleting the source")
+		if err := s.deleteSource(ctx, data.Bucket, data.Name); err != nil {
+			logger.ErrorContext(ctx, "Error deleting original video", "error", err)
+			return "", fmt.Errorf("Store.Delete: %v", err)
+		}
+		return outcomeRedelivered, nil
+	}
+
+	// Count the attempt before doing any work, so a video that crashes or times out
+	// the function is still given up on
+	attempt, err := s.countAttempt(ctx, data, inputAttrs)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error counting the attempt", "error", err)
+		return "", err
+	}
+	f.attempt = attempt
+	if maxAttempts := s.Config.Normalize.MaxAttempts; attempt > maxAttempts {
+		return "", fmt.Errorf("%w in %d attempts", errAttemptsExhausted, attempt-1)
+	}
+
+	// Each invocation works in its own directory so concurrent deliveries of the
+	// same event don't write over each other's files
+	workDir, err := os.MkdirTemp("", "normalize-")
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating workspace", "error", err)
+		return "", fmt.Errorf("os.MkdirTemp: %v", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// The download, probes and encodes stop early enough to leave time to publish the
+	// clip, or to record the failure, before the function is killed
+	work, cancelWork := deadline.Reserve(ctx, s.Config.CleanupReserveDuration())
+	defer cancelWork()
+	inputFilePath := filepath.Join(workDir, "input"+filepath.Ext(data.Name))
+	outputFilePath := filepath.Join(workDir, "normalized.mp4")
+
+	// Download the input file from the store
+	// Note: gsutil is not available in the Cloud Functions runtime
+	inputFile, err := os.Create(inputFilePath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error creating input file", "error", err)
+		return "", fmt.Errorf("os.Create: %v", err)
+	}
+	defer inputFile.Close()
+
+	reader, err := s.Store.Get(work, data.Bucket, data.Name)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error reading input object", "error", err)
+		return "", fmt.Errorf("Store.Get: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(inputFile, reader); err != nil {
+		logger.ErrorContext(ctx, "Error downloading input file", "error", err)
+		return "", fmt.Errorf("io.Copy: %v", err)
+	}
+
+	// Inspect the input so bad files fail with a reason instead of deep inside ffmpeg
+	inputProbe, err := probe.Run(work, inputFilePath)
+	if err == nil {
+		f.probe = inputProbe.Summary()
+		logger.InfoContext(ctx, "Probed input", "probe", f.probe)
+		err = probe.Inspect(inputProbe)
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Rejected input", "error", err)
+		return "", fmt.Errorf("probe: %w", err)
+	}
+
+	outputProfile, fill := s.outputProfile(ctx, logger, inputAttrs.Metadata)
+	clipRange := s.clipRange(ctx, logger, inputAttrs.Metadata, inputProbe.Duration())
+	logger.InfoContext(ctx, "Normalizing to profile", "profile", outputProfile.Name, "fill", fill, "preset", s.Config.Normalize.Preset, "range", clipRange)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("profile", outputProfile.Name),
+		attribute.String("preset", s.Config.Normalize.Preset),
+		attribute.String("range", clipRange.String()))
+
+	// The range's -ss and -to only apply to the source, not the silent track below
+	args := append(clipRange.InputArgs(), "-i", inputFilePath)
+	audioFilter := "aformat=channel_layouts=stereo"
+	if inputProbe.Audio() == nil {
+		// Synthesize a silent track so every clip has audio and the concat step doesn't desync
+		logger.InfoContext(ctx, "Input has no audio stream, adding a silent track")
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=channel_layout=stereo:sample_rate=48000",
+			"-map", fmt.Sprintf("0:%d", inputProbe.Video().Index), "-map", "1:a:0", "-shortest")
+	} else {
+		// First pass measures loudness so the encode can apply loudnorm in linear mode
+		audioFilter = s.measuredLoudnormFilter(work, logger, inputFilePath, clipRange) + "," + audioFilter
+		args = append(args, "-map", fmt.Sprintf("0:%d", inputProbe.Video().Index), "-map", fmt.Sprintf("0:%d", inputProbe.Audio().Index))
+	}
+
+	// Normalize the video using FFmpeg. The preset is validated with the configuration.
+	preset, _ := encoder.Lookup(s.Config.Normalize.Preset)
+	args = append(args, "-vf", outputProfile.Filter(fill), "-af", audioFilter)
+	args = append(args, preset.VideoArgs()...)
+	args = append(args, encoder.AudioArgs()...)
+	args = append(args, outputFilePath)
+	if _, err := s.ffmpeg(logger).Run(work, "encode", args); err != nil {
+		logger.ErrorContext(ctx, "Error running FFmpeg", "error", err)
+		return "", fmt.Errorf("ffmpeg.Run: %w", err)
+	}
+
+	// Make sure the output has the streams concatenate expects before publishing it
+	outputProbe, err := probe.Run(work, outputFilePath)
+	if err == nil {
+		logger.InfoContext(ctx, "Probed output", "probe", outputProbe.Summary())
+		err = probe.InspectNormalized(outputProbe)
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Normalized output failed inspection", "error", err)
+		return "", fmt.Errorf("probe: %w", err)
+	}
+
+	// Record the clip length so concatenate can cap a compilation's duration without downloading
+	// it, and the source generation so a redelivered event knows the clip is published.
+	// The profile tells concatenate which clips can be joined, and the range records
+	// the part of the source the clip is. Failed attempts before this one are no concern
+	// of the clip's.
+	source := withoutFailure(inputAttrs.Metadata)
+	delete(source, metadata.KeyTrimStart)
+	delete(source, metadata.KeyTrimEnd)
+	outputMetadata := metadata.Merge(map[string]string{
+		metadata.KeyProfile:          outputProfile.Name,
+		metadata.KeyFill:             fill,
+		metadata.KeyDuration:         metadata.FormatDuration(outputProbe.Duration()),
+		metadata.KeyCorrelationID:    correlationID,
+		metadata.KeySourceGeneration: strconv.FormatInt(inputAttrs.Generation, 10),
+		metadata.KeyNormalizedAt:     time.Now().UTC().Format(time.RFC3339),
+	}, source)
+	clipRange.Metadata(outputMetadata)
+
+	var fp *fingerprint.Fingerprint
+	if dedupe := s.Config.Normalize.Dedupe; dedupe.Enabled {
+		var match *fingerprint.Match
+		fp, match = s.findDuplicate(work, data.Name, outputFilePath)
+		if match != nil && dedupe.Action == config.DedupeActionSkip {
+			logger.InfoContext(ctx, "Skipping near-duplicate", "duplicateOf", match.Name)
+			if err := s.deleteSource(ctx, data.Bucket, data.Name); err != nil {
+				logger.ErrorContext(ctx, "Error deleting original video", "error", err)
+				return "", fmt.Errorf("Store.Delete: %v", err)
+			}
+			return outcomeSkipped, nil
+		}
+		if match != nil {
+			logger.InfoContext(ctx, "Flagging near-duplicate", "duplicateOf", match.Name)
+			outputMetadata[metadata.KeyDuplicateOf] = match.Name
+			outputMetadata[metadata.KeySimilarity] = fmt.Sprintf("%.3f", match.Video)
+			// Only originals go in the index
+			fp = nil
+		}
+	}
+
+	// Upload the normalized video to the new bucket
+	outputFile, err := os.Open(outputFilePath)
+	if err != nil {
+		logger.ErrorContext(ctx, "Error opening output file", "error", err)
+		return "", fmt.Errorf("os.Open: %v", err)
+	}
+	defer outputFile.Close()
+
+	outputBucket := s.Config.Buckets.Normalized
+	putOptions.ContentType = "video/mp4"
+	putOptions.Metadata = outputMetadata
+	_, err = s.Store.Put(ctx, outputBucket, data.Name, outputFile, putOptions)
+	if errors.Is(err, blobstore.ErrPreconditionFailed) {
+		// Another delivery published first. If it was from the same source there's nothing
+		// left to do but delete it; otherwise fail so the event is retried against the new state.
+		existing, _, statErr := s.published(ctx, data.Name, inputAttrs.Generation)
+		if statErr == nil && existing != nil {
+			logger.InfoContext(ctx, "Clip was published by a concurrent delivery")
+			if err := s.deleteSource(ctx, data.Bucket, data.Name); err != nil {
+				logger.ErrorContext(ctx, "Error deleting original video", "error", err)
+				return "", fmt.Errorf("Store.Delete: %v", err)
+			}
+			return outcomeRedelivered, nil
+		}
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Error uploading normalized video", "error", err)
+		return "", fmt.Errorf("Store.Put: %v", err)
+	}
+
+	if fp != nil {
+		s.indexFingerprint(ctx, data.Name, fp)
+	}
+
+	// Delete the original video file
+	if err := s.deleteSource(ctx, data.Bucket, data.Name); err != nil {
+		logger.ErrorContext(ctx, "Error deleting original video", "error", err)
+		return "", fmt.Errorf("Store.Delete: %v", err)
+	}
+
+	logger.InfoContext(ctx, "Video normalized", "outputBucket", outputBucket, "duration", outputProbe.Duration())
+	if outputMetadata[metadata.KeyDuplicateOf] != "" {
+		return outcomeFlagged, nil
+	}
+	return outcomeNormalized, nil
+}
@@ -0,0 +1,233 @@
+package normalizer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/config"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/testutil"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// failingFFmpeg fails like ffmpeg does on a file it can't read. Tests also use it
+// where ffmpeg must not run.
+const failingFFmpeg = `#!/bin/sh
+echo "Error opening input files: Invalid data found when processing input" >&2
+exit 1
+`
+
+// newTestService returns a service on a local store that runs the fake ffmpeg and ffprobe.
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	testutil.Bin(t, map[string]string{"ffmpeg": testutil.FFmpeg, "ffprobe": testutil.FFprobe})
+	return New(testutil.Store(t), config.Default())
+}
+
+// putSource writes a video to quarantine and returns the finalize event for it.
+func putSource(t *testing.T, s *Service, name string, m map[string]string) event.Event {
+	t.Helper()
+	attrs, err := s.Store.Put(context.Background(), s.Config.Buckets.Quarantine, name, strings.NewReader("video"), &blobstore.PutOptions{Metadata: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return finalizeEvent(t, attrs)
+}
+
+// finalizeEvent returns the event Cloud Storage sends when the object is written.
+func finalizeEvent(t *testing.T, attrs *blobstore.Attrs) event.Event {
+	t.Helper()
+	e := event.New()
+	e.SetID(attrs.Name + "-" + strconv.FormatInt(attrs.Generation, 10))
+	e.SetSource("//storage.googleapis.com/projects/_/buckets/" + attrs.Bucket)
+	e.SetType("google.cloud.storage.object.v1.finalized")
+	data := StorageObjectData{Bucket: attrs.Bucket, Name: attrs.Name, Generation: strconv.FormatInt(attrs.Generation, 10)}
+	if err := e.SetData(event.ApplicationJSON, data); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestNormalizeVideoPublishesClip(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	e := putSource(t, s, "generic-abc.mp4", map[string]string{metadata.KeySubmitter: "alice"})
+
+	if err := s.NormalizeVideo(ctx, e); err != nil {
+		t.Fatalf("NormalizeVideo: %v", err)
+	}
+
+	attrs, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, "generic-abc.mp4")
+	if err != nil {
+		t.Fatalf("normalized clip: %v", err)
+	}
+	if attrs.Metadata[metadata.KeySubmitter] != "alice" || attrs.Metadata[metadata.KeySourceGeneration] == "" {
+		t.Errorf("normalized clip metadata = %v, want the submitter and source generation", attrs.Metadata)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "generic-abc.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("source was not deleted: Stat = %v", err)
+	}
+
+	// A redelivered event finds the source gone and leaves the clip alone
+	if err := s.NormalizeVideo(ctx, e); err != nil {
+		t.Errorf("redelivered NormalizeVideo: %v", err)
+	}
+	again, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, "generic-abc.mp4")
+	if err != nil || again.Generation != attrs.Generation {
+		t.Errorf("normalized clip after redelivery = %+v, %v, want generation %d", again, err, attrs.Generation)
+	}
+}
+
+func TestNormalizeVideoClipRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		metadata      map[string]string
+		maxClipLength float64
+		// args are the input options ffmpeg reads the source with
+		args      string
+		trimStart string
+		trimEnd   string
+	}{
+		{"whole video", nil, 0, "-i ", "", ""},
+		{"picked range", map[string]string{metadata.KeyTrimStart: "1.000", metadata.KeyTrimEnd: "2.500"}, 0, "-ss 1 -to 2.5 -i ", "1.000", "2.500"},
+		{"capped", nil, 2, "-to 2 -i ", "", "2.000"},
+		{"picked range capped", map[string]string{metadata.KeyTrimStart: "0.500"}, 2, "-ss 0.5 -to 2.5 -i ", "0.500", "2.500"},
+		// The fake video is 3 seconds long
+		{"range past the end", map[string]string{metadata.KeyTrimStart: "5.000"}, 0, "-i ", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestService(t)
+			s.Config.Normalize.MaxClipLength = tt.maxClipLength
+			argsFile := filepath.Join(t.TempDir(), "args")
+			t.Setenv("FAKE_FFMPEG_ARGS", argsFile)
+			e := putSource(t, s, "generic-abc.mp4", tt.metadata)
+
+			if err := s.NormalizeVideo(ctx, e); err != nil {
+				t.Fatalf("NormalizeVideo: %v", err)
+			}
+
+			// The loudness analysis and the encode both read only the range
+			args, err := os.ReadFile(argsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(strings.TrimSpace(string(args)), "\n") {
+				if !strings.HasPrefix(line, tt.args) && !strings.Contains(line, " "+tt.args) {
+					t.Errorf("ffmpeg ran with %q, want input options %q", line, tt.args)
+				}
+			}
+
+			attrs, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, "generic-abc.mp4")
+			if err != nil {
+				t.Fatalf("normalized clip: %v", err)
+			}
+			if start, end := attrs.Metadata[metadata.KeyTrimStart], attrs.Metadata[metadata.KeyTrimEnd]; start != tt.trimStart || end != tt.trimEnd {
+				t.Errorf("clip range metadata = %q-%q, want %q-%q", start, end, tt.trimStart, tt.trimEnd)
+			}
+		})
+	}
+}
+
+func TestNormalizeVideoRedeliveredAfterPublish(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	e := putSource(t, s, "generic-abc.mp4", nil)
+	source, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "generic-abc.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An earlier delivery published the clip and stopped before deleting the source
+	m := map[string]string{metadata.KeySourceGeneration: strconv.FormatInt(source.Generation, 10)}
+	if _, err := s.Store.Put(ctx, s.Config.Buckets.Normalized, "generic-abc.mp4", strings.NewReader("clip"), &blobstore.PutOptions{Metadata: m}); err != nil {
+		t.Fatal(err)
+	}
+	testutil.Bin(t, map[string]string{"ffmpeg": failingFFmpeg})
+
+	if err := s.NormalizeVideo(ctx, e); err != nil {
+		t.Fatalf("NormalizeVideo: %v", err)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "generic-abc.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("source was not deleted: Stat = %v", err)
+	}
+}
+
+func TestNormalizeVideoStaleEvent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	stale := putSource(t, s, "generic-abc.mp4", nil)
+	// The video is replaced before the first event is handled
+	putSource(t, s, "generic-abc.mp4", nil)
+	testutil.Bin(t, map[string]string{"ffmpeg": failingFFmpeg})
+
+	if err := s.NormalizeVideo(ctx, stale); err != nil {
+		t.Fatalf("NormalizeVideo: %v", err)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "generic-abc.mp4"); err != nil {
+		t.Errorf("newer source was deleted: Stat = %v", err)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, "generic-abc.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("stale event published a clip: Stat = %v", err)
+	}
+}
+
+func TestNormalizeVideoDeadLettersAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	s.Config.Normalize.MaxAttempts = 2
+	testutil.Bin(t, map[string]string{"ffmpeg": failingFFmpeg})
+	e := putSource(t, s, "broken.mp4", map[string]string{metadata.KeySubmitter: "alice"})
+
+	// The first attempt fails so Eventarc retries it, with the error recorded on the source
+	if err := s.NormalizeVideo(ctx, e); err == nil {
+		t.Fatal("NormalizeVideo succeeded with a failing ffmpeg")
+	}
+	attrs, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "broken.mp4")
+	if err != nil {
+		t.Fatalf("source after the first attempt: %v", err)
+	}
+	if attrs.Metadata[metadata.KeyNormalizeAttempts] != "1" || !strings.Contains(attrs.Metadata[metadata.KeyFFmpegStderr], "Invalid data") {
+		t.Errorf("source metadata after the first attempt = %v", attrs.Metadata)
+	}
+
+	// The last attempt moves it out of the way and stops the retries
+	if err := s.NormalizeVideo(ctx, finalizeEvent(t, attrs)); err != nil {
+		t.Fatalf("last NormalizeVideo: %v", err)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, "broken.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("source was not moved: Stat = %v", err)
+	}
+	failed, err := s.Store.Stat(ctx, s.Config.Buckets.Quarantine, deadLetterPrefix+"broken.mp4")
+	if err != nil {
+		t.Fatalf("failed video: %v", err)
+	}
+	if failed.Metadata[metadata.KeyNormalizeError] == "" {
+		t.Errorf("failed video has no %s: %v", metadata.KeyNormalizeError, failed.Metadata)
+	}
+	if _, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, "broken.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("failed video was published: Stat = %v", err)
+	}
+
+	// Requeueing puts it back with its attempts reset and its attribution kept
+	requeued, err := s.Requeue(ctx, "broken.mp4")
+	if err != nil {
+		t.Fatalf("Requeue: %v", err)
+	}
+	if requeued.Bucket != s.Config.Buckets.Quarantine || requeued.Name != "broken.mp4" {
+		t.Errorf("requeued to %s/%s, want %s/broken.mp4", requeued.Bucket, requeued.Name, s.Config.Buckets.Quarantine)
+	}
+	if requeued.Metadata[metadata.KeyNormalizeAttempts] != "" || requeued.Metadata[metadata.KeySubmitter] != "alice" {
+		t.Errorf("requeued metadata = %v, want no attempts and the submitter", requeued.Metadata)
+	}
+	if _, err := s.Requeue(ctx, "broken.mp4"); !errors.Is(err, blobstore.ErrNotExist) {
+		t.Errorf("second Requeue = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,34 @@
+package normalizer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/profile"
+)
+
+// outputProfile returns the profile and fill a video is normalized to: the ones its
+// metadata picks, or the configured ones. A value in the metadata that isn't valid is
+// logged and ignored rather than failing the video.
+func (s *Service) outputProfile(ctx context.Context, logger *slog.Logger, m map[string]string) (profile.Profile, string) {
+	name := s.Config.Normalize.Profile
+	if picked := m[metadata.KeyProfile]; picked != "" {
+		if _, err := profile.Lookup(picked); err != nil {
+			logger.WarnContext(ctx, "Ignoring the profile in the video's metadata", "error", err)
+		} else {
+			name = picked
+		}
+	}
+	fill := s.Config.Normalize.Fill
+	if picked := m[metadata.KeyFill]; picked != "" {
+		if err := profile.ValidateFill(picked); err != nil {
+			logger.WarnContext(ctx, "Ignoring the fill in the video's metadata", "error", err)
+		} else {
+			fill = picked
+		}
+	}
+	// Validated with the configuration
+	p, _ := profile.Lookup(name)
+	return p, fill
+}
@@ -0,0 +1,59 @@
+package normalizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/blobstore"
+	"github.com/DC00/meme-compiler-cloud-functions/shared/metadata"
+)
+
+// Eventarc delivers every event at least once, and a crash can stop the function
+// anywhere between publishing the normalized clip and deleting the source. Each
+// normalized object records the generation of the source it was made from, so a
+// redelivered event can tell that its work is already done, and the clip is
+// published with a precondition so two deliveries running at once can't both
+// write it.
+
+// published returns the normalized object made from this generation of the source,
+// or nil with the precondition for publishing it: the object must still not exist,
+// or must still be the clip from an older source of the same name.
+func (s *Service) published(ctx context.Context, name string, sourceGeneration int64) (*blobstore.Attrs, *blobstore.PutOptions, error) {
+	existing, err := s.Store.Stat(ctx, s.Config.Buckets.Normalized, name)
+	if errors.Is(err, blobstore.ErrNotExist) {
+		return nil, &blobstore.PutOptions{IfNotExists: true}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Store.Stat: %v", err)
+	}
+	if madeFrom(existing, sourceGeneration) {
+		return existing, nil, nil
+	}
+	return nil, &blobstore.PutOptions{IfGenerationMatch: existing.Generation}, nil
+}
+
+// madeFrom reports whether the normalized object was made from the source generation.
+func madeFrom(normalized *blobstore.Attrs, sourceGeneration int64) bool {
+	return normalized.Metadata[metadata.KeySourceGeneration] == strconv.FormatInt(sourceGeneration, 10)
+}
+
+// deleteSource removes the source once its clip is published. It is already gone
+// when an earlier delivery of the event got this far.
+func (s *Service) deleteSource(ctx context.Context, bucket, name string) error {
+	err := s.Store.Delete(ctx, bucket, name)
+	if err != nil && !errors.Is(err, blobstore.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// staleEvent reports whether the event is for an older generation than the object
+// now in the bucket. The newer generation has its own event.
+func staleEvent(data StorageObjectData, source *blobstore.Attrs) bool {
+	if data.Generation == "" {
+		return false
+	}
+	return data.Generation != strconv.FormatInt(source.Generation, 10)
+}
@@ -0,0 +1,29 @@
+package normalizer
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/DC00/meme-compiler-cloud-functions/shared/trim"
+)
+
+// clipRange returns the part of a source of duration seconds that becomes the clip: the
+// range its metadata picks, cut down to MAX_CLIP_LENGTH. A range in the metadata that
+// isn't valid, or starts past the end of the source, is logged and ignored rather than
+// failing the video.
+func (s *Service) clipRange(ctx context.Context, logger *slog.Logger, m map[string]string, duration float64) trim.Range {
+	r, err := trim.FromMetadata(m)
+	if err == nil {
+		err = r.Check(duration)
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Ignoring the clip range in the video's metadata", "error", err)
+		r = trim.Range{}
+	}
+	maxLength := s.Config.Normalize.MaxClipLength
+	if capped, ok := r.Cap(duration, maxLength); ok {
+		logger.InfoContext(ctx, "Cutting the clip to the maximum length", "length", r.Length(duration), "maxClipLength", maxLength, "range", capped)
+		r = capped
+	}
+	return r
+}